@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/andrewheberle/onms-grpc-receiver/pkg/bsm"
+	"github.com/andrewheberle/onms-grpc-receiver/pkg/server"
+	"github.com/bep/simplecobra"
+	"google.golang.org/grpc"
+)
+
+type bsmCommand struct {
+	srv *server.BSMServer
+
+	*receiverCommand
+}
+
+func (c *bsmCommand) PreRun(this, runner *simplecobra.Commandeer) error {
+	opts, err := c.serverOptions(this, runner)
+	if err != nil {
+		return err
+	}
+
+	// set up server
+	srv, err := server.NewBSMServer(opts...)
+	if err != nil {
+		return err
+	}
+	c.srv = srv
+
+	c.logger.Debug("completed PreRun", "command", this.CobraCommand.Name())
+
+	return nil
+}
+
+func (c *bsmCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	return c.serve(c.srv.ServiceSyncServer, bsm.ServiceSync_ServiceDesc.ServiceName, func(g *grpc.Server) {
+		bsm.RegisterServiceSyncServer(g, c.srv)
+	})
+}
@@ -42,18 +42,34 @@ func Execute(args []string) error {
 	}
 
 	spog := &spogCommand{
-		Command: vipercommand.New(
-			"spog",
-			"Run in SPoG mode",
-			simplecommand.Long(`Run in Service Provider over gRPC (SPoG) mode. In this mode gRPC messages from any number of downstream
-OpenNMS Horizon instances may be handled as all Heartbeat and AlarmUpdate messages include details of the downstream Horizon instance. Inventory and Event updates are not handled in this mode, only HeartBeat and Alarm updates.`),
-		),
+		receiverCommand: &receiverCommand{
+			Command: vipercommand.New(
+				"spog",
+				"Run in SPoG mode",
+				simplecommand.Long(`Run in Service Provider over gRPC (SPoG) mode. In this mode gRPC messages from any number of downstream
+OpenNMS Horizon instances may be handled as all Heartbeat and AlarmUpdate messages include details of the downstream Horizon instance. HeartBeat, Alarm, Inventory and Event updates are all handled in this mode.`),
+			),
+		},
 	}
 	spog.EnvKeyReplacer = strings.NewReplacer("-", "_", ".", "_")
 	spog.EnvPrefix = "onms_grpc"
 
+	bsm := &bsmCommand{
+		receiverCommand: &receiverCommand{
+			Command: vipercommand.New(
+				"bsm",
+				"Run in BSM mode",
+				simplecommand.Long(`Run in Business Service Monitoring (BSM) mode. In this mode the receiver accepts gRPC messages from the OpenNMS
+monitored services exporter and unhealthy service states are sent to Alertmanager. Inventory updates are not handled in this mode, only HeartBeat and State updates.`),
+			),
+		},
+	}
+	bsm.EnvKeyReplacer = strings.NewReplacer("-", "_", ".", "_")
+	bsm.EnvPrefix = "onms_grpc"
+
 	rootCmd.Command.SubCommands = []simplecobra.Commander{
 		spog,
+		bsm,
 		&versionCommand{
 			Command: simplecommand.New("version", "Print version information"),
 		},
@@ -0,0 +1,484 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/andrewheberle/onms-grpc-receiver/pkg/server"
+	"github.com/andrewheberle/simplecommand/vipercommand"
+	"github.com/bep/simplecobra"
+	"github.com/cloudflare/certinel/fswatcher"
+	"github.com/oklog/run"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// receiverCommand holds the flags and run logic shared by the spog and bsm commands
+type receiverCommand struct {
+	logger *slog.Logger
+
+	opts []grpc.ServerOption
+
+	cert               string
+	key                string
+	clientCA           string
+	clientMapping      map[string]string
+	tokenFile          string
+	listenAddress      string
+	metricsAddress     string
+	metricsPath        string
+	metricsAdmin       bool
+	alertManagers      []string
+	alertManagerScheme string
+	alertManagerSrv    string
+	amTLSCA            string
+	amTLSCert          string
+	amTLSKey           string
+	amTLSServerName    string
+	amTLSInsecure      bool
+	amUsername         string
+	amPasswordFile     string
+	amBearerTokenFile  string
+	urlMapping         map[string]string
+	resolveTimeout     time.Duration
+	srvCacheTTL        time.Duration
+	refreshInterval    time.Duration
+	heartbeatTimeout   time.Duration
+	queueDir           string
+	queueRetry         time.Duration
+	queueMaxAge        time.Duration
+	sendTimeout        time.Duration
+	retries            int
+	retryBackoff       time.Duration
+	retryMaxBackoff    time.Duration
+	breakerThreshold   int
+	breakerCooldown    time.Duration
+	probeInterval      time.Duration
+	grpcReflection     bool
+	configFile         string
+	sdPath             string
+	sdPort             int
+	sdFile             string
+	sdFileInterval     time.Duration
+	silences           bool
+	silenceFile        string
+	silenceDuration    time.Duration
+
+	debug   bool
+	silent  bool
+	verbose bool
+
+	headers map[string]string
+
+	*vipercommand.Command
+}
+
+func (c *receiverCommand) Init(cd *simplecobra.Commandeer) error {
+	if err := c.Command.Init(cd); err != nil {
+		return err
+	}
+
+	cmd := cd.CobraCommand
+	cmd.Flags().StringVar(&c.cert, "cert", "", "TLS Certificate")
+	cmd.Flags().StringVar(&c.key, "key", "", "TLS Key")
+	cmd.MarkFlagsRequiredTogether("cert", "key")
+	cmd.Flags().StringVar(&c.clientCA, "client-ca", "", "CA certificate used to require and verify client certificates on the gRPC listener")
+	cmd.Flags().StringToStringVar(&c.clientMapping, "map.client", map[string]string{}, "Map client certificate subject CN or SAN to allowed instance ID's (separated by |)")
+	cmd.Flags().StringVar(&c.tokenFile, "auth.tokens", "", "File of bearer tokens required on the gRPC listener, which is reloaded on change")
+	cmd.Flags().StringVar(&c.listenAddress, "address", "localhost:8080", "Service gRPC listen address")
+	cmd.Flags().BoolVar(&c.grpcReflection, "grpc.reflection", false, "Enable gRPC server reflection")
+	cmd.Flags().StringVar(&c.metricsAddress, "metrics.address", "", "Metrics listen address")
+	cmd.Flags().StringVar(&c.metricsPath, "metrics.path", "/metrics", "Metrics path")
+	cmd.Flags().BoolVar(&c.metricsAdmin, "metrics.admin", false, "Enable the admin API on the metrics service")
+	cmd.Flags().StringSliceVar(&c.alertManagers, "alertmanager.url", []string{}, "Alertmanager URL")
+	cmd.Flags().StringVar(&c.alertManagerScheme, "alertmanager.scheme", "http", "Alertmanager scheme (http/https) when SRV records are used")
+	cmd.Flags().StringVar(&c.alertManagerSrv, "alertmanager.srv", "", "Alertmanager SRV Record")
+	cmd.MarkFlagsMutuallyExclusive("alertmanager.url", "alertmanager.srv")
+	cmd.Flags().StringVar(&c.amTLSCA, "alertmanager.tls.ca", "", "CA certificate used to verify Alertmanager")
+	cmd.Flags().StringVar(&c.amTLSCert, "alertmanager.tls.cert", "", "Client certificate for connections to Alertmanager")
+	cmd.Flags().StringVar(&c.amTLSKey, "alertmanager.tls.key", "", "Client key for connections to Alertmanager")
+	cmd.MarkFlagsRequiredTogether("alertmanager.tls.cert", "alertmanager.tls.key")
+	cmd.Flags().StringVar(&c.amTLSServerName, "alertmanager.tls.server-name", "", "Server name used to verify the certificate of Alertmanager")
+	cmd.Flags().BoolVar(&c.amTLSInsecure, "alertmanager.tls.insecure-skip-verify", false, "Disable verification of the certificate of Alertmanager")
+	cmd.Flags().StringVar(&c.amUsername, "alertmanager.basic-auth.username", "", "Username for basic authentication to Alertmanager")
+	cmd.Flags().StringVar(&c.amPasswordFile, "alertmanager.basic-auth.password-file", "", "File containing the password for basic authentication to Alertmanager")
+	cmd.Flags().StringVar(&c.amBearerTokenFile, "alertmanager.bearer-token-file", "", "File containing a bearer token for Alertmanager")
+	cmd.MarkFlagsMutuallyExclusive("alertmanager.basic-auth.username", "alertmanager.bearer-token-file")
+	cmd.Flags().DurationVar(&c.sendTimeout, "alertmanager.timeout", time.Second*5, "Timeout for requests to Alertmanager")
+	cmd.Flags().IntVar(&c.retries, "alertmanager.retries", 3, "Number of retries for failed requests to Alertmanager")
+	cmd.Flags().DurationVar(&c.retryBackoff, "alertmanager.backoff", time.Millisecond*500, "Initial backoff between retries to Alertmanager")
+	cmd.Flags().DurationVar(&c.retryMaxBackoff, "alertmanager.backoff.max", time.Second*10, "Maximum backoff between retries to Alertmanager")
+	cmd.Flags().IntVar(&c.breakerThreshold, "alertmanager.breaker.threshold", 5, "Consecutive failures before requests to an Alertmanager are stopped (0 to disable)")
+	cmd.Flags().DurationVar(&c.breakerCooldown, "alertmanager.breaker.cooldown", time.Second*30, "Time before requests to a failed Alertmanager are tried again")
+	cmd.Flags().DurationVar(&c.probeInterval, "alertmanager.probe.interval", time.Second*30, "Interval to probe the status of Alertmanager for readiness (0 to disable)")
+	cmd.Flags().BoolVar(&c.silences, "alertmanager.silences", false, "Create Alertmanager silences for acknowledged alarms")
+	cmd.Flags().StringVar(&c.silenceFile, "alertmanager.silences.file", "", "File to track silences for acknowledged alarms across restarts")
+	cmd.Flags().DurationVar(&c.silenceDuration, "alertmanager.silences.duration", time.Hour*24, "Duration of silences for acknowledged alarms, which are renewed while the alarm is acknowledged")
+	cmd.Flags().StringToStringVar(&c.headers, "headers", map[string]string{}, "Custom headers")
+	cmd.Flags().StringToStringVar(&c.urlMapping, "map.url", map[string]string{}, "Map instance ID's to URLs")
+	cmd.Flags().DurationVar(&c.resolveTimeout, "resolve.timeout", time.Minute*5, "Resolve timeout for alarms")
+	cmd.Flags().DurationVar(&c.srvCacheTTL, "srv.ttl", time.Second*30, "TTL for resolved SRV records")
+	cmd.Flags().DurationVar(&c.refreshInterval, "refresh.interval", time.Minute, "Interval to re-send active alarms (0 to disable)")
+	cmd.Flags().StringVar(&c.queueDir, "queue.dir", "", "Directory for a durable alarm queue")
+	cmd.Flags().DurationVar(&c.queueRetry, "queue.retry", time.Minute, "Interval to retry undelivered alarms in the durable queue (0 to disable)")
+	cmd.Flags().DurationVar(&c.queueMaxAge, "queue.max-age", time.Hour*24, "Maximum age of undelivered alarms in the durable queue (0 to keep forever)")
+	cmd.Flags().StringVar(&c.configFile, "config.file", "", "Alert configuration file")
+	cmd.Flags().StringVar(&c.sdPath, "sd.path", "", "Path for Prometheus HTTP service discovery on the metrics service")
+	cmd.Flags().IntVar(&c.sdPort, "sd.port", 0, "Port added to service discovery targets")
+	cmd.Flags().StringVar(&c.sdFile, "sd.file", "", "File to write Prometheus file_sd targets to")
+	cmd.Flags().DurationVar(&c.sdFileInterval, "sd.file.interval", time.Second*30, "Interval to write file_sd targets")
+	cmd.Flags().DurationVar(&c.heartbeatTimeout, "heartbeat.timeout", time.Minute*5, "Time without a heartbeat before an instance is considered down (0 to disable)")
+
+	cmd.Flags().BoolVar(&c.debug, "debug", false, "Enable debug logging")
+	cmd.Flags().BoolVar(&c.silent, "silent", false, "Disable all logging")
+	cmd.Flags().BoolVar(&c.verbose, "verbose", false, "Log all messages")
+
+	return nil
+}
+
+// serverOptions sets up logging and returns the options used to create the
+// underlying server based on the command line flags
+func (c *receiverCommand) serverOptions(this, runner *simplecobra.Commandeer) ([]server.ServiceSyncServerOption, error) {
+	if err := c.Command.PreRun(this, runner); err != nil {
+		return nil, err
+	}
+
+	// set up logger
+	logLevel := new(slog.LevelVar)
+	if c.silent {
+		c.logger = slog.New(slog.DiscardHandler)
+	} else {
+		c.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	}
+
+	// switch on debug
+	if c.debug {
+		logLevel.Set(slog.LevelDebug)
+	}
+
+	// server options
+	opts := []server.ServiceSyncServerOption{
+		server.WithLogger(c.logger),
+		server.WithURLMapping(c.urlMapping),
+		server.WithResolveTimeout(c.resolveTimeout),
+		server.WithSRVCacheTTL(c.srvCacheTTL),
+		server.WithRefreshInterval(c.refreshInterval),
+		server.WithHeartbeatTimeout(c.heartbeatTimeout),
+		server.WithSendTimeout(c.sendTimeout),
+		server.WithRetries(c.retries),
+		server.WithRetryBackoff(c.retryBackoff, c.retryMaxBackoff),
+		server.WithCircuitBreaker(c.breakerThreshold, c.breakerCooldown),
+		server.WithProbeInterval(c.probeInterval),
+		server.WithServiceDiscoveryPort(c.sdPort),
+	}
+
+	// set up alertmanager via url
+	if len(c.alertManagers) > 0 {
+		c.logger.Debug("set up alertmanager", "urls", c.alertManagers)
+
+		opts = append(opts, server.WithAlertmanagerUrl(c.alertManagers))
+	}
+
+	// set up alertmanager via SRV
+	if c.alertManagerSrv != "" {
+		c.logger.Debug("set up alertmanager", "scheme", c.alertManagerScheme, "srv", c.alertManagerSrv)
+
+		opts = append(opts, server.WithAlertManagerSrv(c.alertManagerScheme, c.alertManagerSrv))
+	}
+
+	// set up TLS and credentials for alertmanager
+	if httpConfig, ok := c.alertmanagerHTTPConfig(); ok {
+		c.logger.Debug("set up alertmanager http config", "ca", c.amTLSCA, "cert", c.amTLSCert, "server_name", c.amTLSServerName, "insecure_skip_verify", c.amTLSInsecure, "basic_auth", httpConfig.BasicAuth != nil, "bearer_token_file", c.amBearerTokenFile)
+
+		opts = append(opts, server.WithHTTPClientConfig(httpConfig))
+	}
+
+	// enable durable queue
+	if c.queueDir != "" {
+		c.logger.Debug("set up durable queue", "dir", c.queueDir)
+
+		opts = append(opts,
+			server.WithQueueDir(c.queueDir),
+			server.WithQueueRetryInterval(c.queueRetry),
+			server.WithQueueMaxAge(c.queueMaxAge),
+		)
+	}
+
+	// silence acknowledged alarms
+	if c.silences {
+		c.logger.Debug("set up silences for acknowledged alarms", "file", c.silenceFile, "duration", c.silenceDuration)
+
+		opts = append(opts, server.WithAckSilences(c.silenceFile, c.silenceDuration))
+	}
+
+	// write file_sd targets
+	if c.sdFile != "" {
+		c.logger.Debug("set up file_sd", "file", c.sdFile, "interval", c.sdFileInterval)
+
+		opts = append(opts, server.WithFileSD(c.sdFile, c.sdFileInterval))
+	}
+
+	// load alert configuration
+	if c.configFile != "" {
+		c.logger.Debug("loading alert configuration", "file", c.configFile)
+
+		cfg, err := server.LoadConfig(c.configFile)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, server.WithConfig(cfg))
+	}
+
+	// add custom headers if set
+	if len(c.headers) > 0 {
+		opts = append(opts, server.WithHeaders(c.headers))
+	}
+
+	// enable verbose logging
+	if c.verbose {
+		opts = append(opts, server.WithVerbose())
+	}
+
+	return opts, nil
+}
+
+// alertmanagerHTTPConfig returns the TLS configuration and credentials for
+// Alertmanager and whether any were set
+func (c *receiverCommand) alertmanagerHTTPConfig() (server.HTTPClientConfig, bool) {
+	cfg := server.HTTPClientConfig{
+		TLSConfig: server.TLSConfig{
+			CAFile:             c.amTLSCA,
+			CertFile:           c.amTLSCert,
+			KeyFile:            c.amTLSKey,
+			ServerName:         c.amTLSServerName,
+			InsecureSkipVerify: c.amTLSInsecure,
+		},
+		BearerTokenFile: c.amBearerTokenFile,
+	}
+
+	if c.amUsername != "" || c.amPasswordFile != "" {
+		cfg.BasicAuth = &server.BasicAuth{
+			Username:     c.amUsername,
+			PasswordFile: c.amPasswordFile,
+		}
+	}
+
+	return cfg, cfg != server.HTTPClientConfig{}
+}
+
+// serve runs the gRPC receiver, batch message handler and optional metrics
+// service until one of them exits. The register function is used to register
+// the gRPC service implementation with the gRPC server, which is reported by
+// the health service under the provided service name.
+func (c *receiverCommand) serve(srv *server.ServiceSyncServer, service string, register func(*grpc.Server)) error {
+	var tlsConfig *tls.Config
+
+	// set up listener
+	l, err := net.Listen("tcp", c.listenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	defer l.Close()
+
+	g := run.Group{}
+
+	// set up TLS for gRPC
+	if c.cert != "" && c.key != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		certinel, err := fswatcher.New(c.cert, c.key)
+		if err != nil {
+			return fmt.Errorf("cannot set up fswatcher: %w", err)
+		}
+
+		g.Add(func() error {
+			c.logger.Info("started certificate watcher", "cert", c.cert, "key", c.key)
+
+			return certinel.Start(ctx)
+		}, func(err error) {
+			cancel()
+		})
+
+		tlsConfig = &tls.Config{
+			GetCertificate: certinel.GetCertificate,
+		}
+
+		// client certificates are only required for gRPC
+		grpcTLSConfig := tlsConfig.Clone()
+		if c.clientCA != "" {
+			pool, err := loadCertPool(c.clientCA)
+			if err != nil {
+				return err
+			}
+
+			grpcTLSConfig.ClientCAs = pool
+			grpcTLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		c.opts = append(c.opts, grpc.Creds(credentials.NewTLS(grpcTLSConfig)))
+	} else if c.clientCA != "" {
+		return fmt.Errorf("--client-ca requires --cert and --key")
+	}
+
+	// check the instance of each message against the client certificate
+	if len(c.clientMapping) > 0 {
+		if c.clientCA == "" {
+			return fmt.Errorf("--map.client requires --client-ca")
+		}
+
+		identities, err := server.ParseClientIdentities(c.clientMapping)
+		if err != nil {
+			return fmt.Errorf("invalid client mapping: %w", err)
+		}
+
+		c.opts = append(c.opts, grpc.ChainStreamInterceptor(srv.IdentityStreamInterceptor(identities)))
+	}
+
+	// require a bearer token on each stream
+	if c.tokenFile != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tokens, err := server.NewTokenFile(c.tokenFile, c.logger)
+		if err != nil {
+			return err
+		}
+
+		g.Add(func() error {
+			c.logger.Info("started token watcher", "file", c.tokenFile)
+
+			return tokens.Start(ctx)
+		}, func(err error) {
+			cancel()
+		})
+
+		c.opts = append(c.opts, grpc.ChainStreamInterceptor(srv.TokenStreamInterceptor(tokens)))
+	}
+
+	// create register and add server to run group
+	grpcServer := grpc.NewServer(c.opts...)
+	register(grpcServer)
+
+	healthServer := registerHealth(grpcServer, service)
+
+	// enable reflection for debugging via grpcurl
+	if c.grpcReflection {
+		reflection.Register(grpcServer)
+	}
+
+	g.Add(func() error {
+		c.logger.Info("started gRPC receiver", "address", c.listenAddress)
+
+		srv.SetServing(true)
+		return grpcServer.Serve(l)
+	}, func(err error) {
+		srv.SetServing(false)
+		healthServer.Shutdown()
+		go func() {
+			timer := time.AfterFunc(3*time.Second, func() {
+				grpcServer.Stop()
+			})
+			defer timer.Stop()
+			grpcServer.GracefulStop()
+		}()
+	})
+
+	// set up batch message handler
+	g.Add(func() error {
+		c.logger.Info("started gRPC message handler")
+
+		return srv.Start()
+	}, func(err error) {
+		srv.Shutdown()
+	})
+
+	// set up metrics
+	if c.metricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/-/healthy", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Healthy"))
+		})
+		mux.Handle("/-/ready", srv.ReadyHandler())
+		mux.Handle(c.metricsPath, srv.MetricsHandler())
+		if c.sdPath != "" {
+			mux.Handle(c.sdPath, srv.ServiceDiscoveryHandler())
+		}
+		if c.metricsAdmin {
+			mux.Handle("/admin/", srv.AdminHandler())
+		}
+
+		srv := &http.Server{
+			Addr:    c.metricsAddress,
+			Handler: mux,
+		}
+
+		if c.cert != "" && c.key != "" {
+			// add TLS config
+			srv.TLSConfig = tlsConfig
+			g.Add(func() error {
+				// run tls server
+				c.logger.Info("started metrics service", "address", c.metricsAddress, "path", c.metricsPath, "cert", c.cert, "key", c.key)
+				return srv.ListenAndServeTLS("", "")
+			}, func(err error) {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+					srv.Shutdown(ctx)
+					cancel()
+				}()
+			})
+		} else {
+			g.Add(func() error {
+				// run non tls server
+				c.logger.Info("started metrics service", "address", c.metricsAddress, "path", c.metricsPath)
+				return srv.ListenAndServe()
+			}, func(err error) {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+					srv.Shutdown(ctx)
+					cancel()
+				}()
+			})
+		}
+	}
+
+	return g.Run()
+}
+
+// loadCertPool reads a PEM encoded CA certificate bundle
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+
+	return pool, nil
+}
+
+// registerHealth registers the standard health service with the gRPC server,
+// reporting the receiver service as serving until the health server is shut
+// down
+func registerHealth(g *grpc.Server, service string) *health.Server {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(g, healthServer)
+
+	return healthServer
+}
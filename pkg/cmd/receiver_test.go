@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/andrewheberle/onms-grpc-receiver/pkg/server"
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRegisterHealth(t *testing.T) {
+	srv, err := server.NewServiceSyncServer()
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	l := bufconn.Listen(1024 * 1024)
+	g := grpc.NewServer()
+	pb.RegisterNmsInventoryServiceSyncServer(g, srv)
+	healthServer := registerHealth(g, pb.NmsInventoryServiceSync_ServiceDesc.ServiceName)
+	go g.Serve(l)
+	defer g.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Check(%q) error = %v", service, err)
+		}
+
+		return resp.GetStatus()
+	}
+
+	tests := []struct {
+		name     string
+		shutdown bool
+		want     healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"serving", false, healthpb.HealthCheckResponse_SERVING},
+		{"shutdown", true, healthpb.HealthCheckResponse_NOT_SERVING},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.shutdown {
+				healthServer.Shutdown()
+			}
+
+			for _, service := range []string{"", "org.opennms.plugin.grpc.proto.spog.NmsInventoryServiceSync"} {
+				if got := check(service); got != tt.want {
+					t.Errorf("Check(%q) = %v, want %v", service, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+type activeAlarmKey struct {
+	instanceID string
+	alarmID    uint64
+}
+
+type activeReductionKey struct {
+	instanceID   string
+	reductionKey string
+}
+
+// activeServiceKey identifies an unhealthy business service
+type activeServiceKey struct {
+	foreignType    string
+	foreignSource  string
+	foreignService string
+}
+
+type activeAlarm struct {
+	alert        Alert
+	reductionKey string
+
+	// labels are the labels of the alert before relabeling, so the
+	// situation label can be changed and the alert relabeled again
+	labels map[string]string
+
+	// suppressed alarms are members of a situation that are not re-sent
+	// until the situation is removed
+	suppressed bool
+}
+
+// activeAlarms is a table of alarms that are currently firing, which are
+// periodically re-sent to Alertmanager so they are not auto-resolved
+type activeAlarms struct {
+	mu     sync.RWMutex
+	alarms map[activeAlarmKey]activeAlarm
+
+	// reductions indexes alarms by reduction key for clear correlation
+	reductions map[activeReductionKey]uint64
+
+	// services are the unhealthy business services
+	services map[activeServiceKey]Alert
+}
+
+func newActiveAlarms() *activeAlarms {
+	return &activeAlarms{
+		alarms:     make(map[activeAlarmKey]activeAlarm),
+		reductions: make(map[activeReductionKey]uint64),
+		services:   make(map[activeServiceKey]Alert),
+	}
+}
+
+func (a *activeAlarms) set(instanceID string, alarmID uint64, v activeAlarm) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.remove(activeAlarmKey{instanceID, alarmID})
+	a.alarms[activeAlarmKey{instanceID, alarmID}] = v
+	if v.reductionKey != "" {
+		a.reductions[activeReductionKey{instanceID, v.reductionKey}] = alarmID
+	}
+}
+
+func (a *activeAlarms) delete(instanceID string, alarmID uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.remove(activeAlarmKey{instanceID, alarmID})
+}
+
+// clear removes the alarm with the reduction key and returns its alert if
+// it was found
+func (a *activeAlarms) clear(instanceID, reductionKey string) (Alert, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	alarmID, ok := a.reductions[activeReductionKey{instanceID, reductionKey}]
+	if !ok {
+		return Alert{}, false
+	}
+
+	v := a.alarms[activeAlarmKey{instanceID, alarmID}]
+	a.remove(activeAlarmKey{instanceID, alarmID})
+
+	return v.alert, true
+}
+
+// pop removes the alarm and returns its alert if it was found
+func (a *activeAlarms) pop(instanceID string, alarmID uint64) (Alert, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v, ok := a.alarms[activeAlarmKey{instanceID, alarmID}]
+	a.remove(activeAlarmKey{instanceID, alarmID})
+
+	return v.alert, ok
+}
+
+// get returns the alarm if it was found
+func (a *activeAlarms) get(instanceID string, alarmID uint64) (activeAlarm, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	v, ok := a.alarms[activeAlarmKey{instanceID, alarmID}]
+
+	return v, ok
+}
+
+// remove deletes an alarm and its reduction key, the lock must be held
+func (a *activeAlarms) remove(k activeAlarmKey) {
+	v, ok := a.alarms[k]
+	if !ok {
+		return
+	}
+
+	rk := activeReductionKey{k.instanceID, v.reductionKey}
+	if a.reductions[rk] == k.alarmID {
+		delete(a.reductions, rk)
+	}
+	delete(a.alarms, k)
+}
+
+// setService sets the alert for an unhealthy business service, keeping the
+// start time of the alert if the service was already unhealthy, and returns
+// the alert
+func (a *activeAlarms) setService(k activeServiceKey, alert Alert) Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if v, ok := a.services[k]; ok {
+		alert.StartsAt = v.StartsAt
+	}
+	a.services[k] = alert
+
+	return alert
+}
+
+// popService removes a business service and returns its alert if it was
+// found
+func (a *activeAlarms) popService(k activeServiceKey) (Alert, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v, ok := a.services[k]
+	delete(a.services, k)
+
+	return v, ok
+}
+
+// retain removes all alarms for the instance that are not in keep and
+// returns the removed alerts
+func (a *activeAlarms) retain(instanceID string, keep map[uint64]bool) []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed := make([]Alert, 0)
+	for k, v := range a.alarms {
+		if k.instanceID != instanceID || keep[k.alarmID] {
+			continue
+		}
+
+		removed = append(removed, v.alert)
+		a.remove(k)
+	}
+
+	return removed
+}
+
+func (a *activeAlarms) len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.alarms) + len(a.services)
+}
+
+// activeAlarmEntry is a single alarm in the active alarm table
+type activeAlarmEntry struct {
+	instanceID   string
+	alarmID      uint64
+	reductionKey string
+	suppressed   bool
+	alert        Alert
+}
+
+// list returns all active alarms
+func (a *activeAlarms) list() []activeAlarmEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	list := make([]activeAlarmEntry, 0, len(a.alarms))
+	for k, v := range a.alarms {
+		list = append(list, activeAlarmEntry{
+			instanceID:   k.instanceID,
+			alarmID:      k.alarmID,
+			reductionKey: v.reductionKey,
+			suppressed:   v.suppressed,
+			alert:        v.alert,
+		})
+	}
+
+	return list
+}
+
+// activeServiceEntry is a single unhealthy business service
+type activeServiceEntry struct {
+	key   activeServiceKey
+	alert Alert
+}
+
+// listServices returns all unhealthy business services
+func (a *activeAlarms) listServices() []activeServiceEntry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	list := make([]activeServiceEntry, 0, len(a.services))
+	for k, v := range a.services {
+		list = append(list, activeServiceEntry{key: k, alert: v})
+	}
+
+	return list
+}
+
+// refresh extends the end time of all active alarms that are not suppressed
+// to now plus the timeout and returns the alerts to be re-sent
+func (a *activeAlarms) refresh(now time.Time, timeout time.Duration) []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]Alert, 0, len(a.alarms)+len(a.services))
+	for k, v := range a.alarms {
+		if v.suppressed {
+			continue
+		}
+
+		v.alert.EndsAt = now.Add(timeout)
+		a.alarms[k] = v
+
+		list = append(list, v.alert)
+	}
+	for k, v := range a.services {
+		v.EndsAt = now.Add(timeout)
+		a.services[k] = v
+
+		list = append(list, v)
+	}
+
+	return list
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func testAlert(alertname string) Alert {
+	return Alert{
+		Labels: map[string]string{"alertname": alertname},
+	}
+}
+
+func TestActiveAlarmsSetDelete(t *testing.T) {
+	a := newActiveAlarms()
+
+	a.set("instance1", 1, activeAlarm{alert: testAlert("one")})
+	a.set("instance1", 2, activeAlarm{alert: testAlert("two")})
+	a.set("instance2", 1, activeAlarm{alert: testAlert("three")})
+	if got := a.len(); got != 3 {
+		t.Errorf("len() = %d, want 3", got)
+	}
+
+	// replacing an alarm does not add a new entry
+	a.set("instance1", 1, activeAlarm{alert: testAlert("one")})
+	if got := a.len(); got != 3 {
+		t.Errorf("len() after replace = %d, want 3", got)
+	}
+
+	a.delete("instance1", 1)
+	if got := a.len(); got != 2 {
+		t.Errorf("len() after delete = %d, want 2", got)
+	}
+
+	// deleting a missing alarm is a no-op
+	a.delete("instance3", 1)
+	if got := a.len(); got != 2 {
+		t.Errorf("len() after missing delete = %d, want 2", got)
+	}
+}
+
+func TestActiveAlarmsRetain(t *testing.T) {
+	a := newActiveAlarms()
+
+	a.set("instance1", 1, activeAlarm{alert: testAlert("one")})
+	a.set("instance1", 2, activeAlarm{alert: testAlert("two")})
+	a.set("instance2", 3, activeAlarm{alert: testAlert("three")})
+
+	removed := a.retain("instance1", map[uint64]bool{1: true})
+	if len(removed) != 1 || removed[0].Labels["alertname"] != "two" {
+		t.Errorf("retain() removed = %v, want alarm two", removed)
+	}
+
+	// other instances are not affected
+	if got := a.len(); got != 2 {
+		t.Errorf("len() after retain = %d, want 2", got)
+	}
+
+	// an empty snapshot removes everything for the instance
+	removed = a.retain("instance2", map[uint64]bool{})
+	if len(removed) != 1 || removed[0].Labels["alertname"] != "three" {
+		t.Errorf("retain() removed = %v, want alarm three", removed)
+	}
+}
+
+func TestActiveAlarmsRefresh(t *testing.T) {
+	a := newActiveAlarms()
+	a.set("instance1", 1, activeAlarm{alert: testAlert("one")})
+
+	now := time.Now()
+	list := a.refresh(now, time.Minute*5)
+	if len(list) != 1 {
+		t.Fatalf("refresh() returned %d alerts, want 1", len(list))
+	}
+
+	if got := list[0].EndsAt; !got.Equal(now.Add(time.Minute * 5)) {
+		t.Errorf("refresh() EndsAt = %v, want %v", got, now.Add(time.Minute*5))
+	}
+
+	// suppressed alarms are not re-sent
+	a.set("instance1", 2, activeAlarm{alert: testAlert("two"), suppressed: true})
+	if list := a.refresh(now, time.Minute*5); len(list) != 1 {
+		t.Errorf("refresh() with suppressed alarm returned %d alerts, want 1", len(list))
+	}
+}
+
+func TestHandleAlarmsLongLived(t *testing.T) {
+	srv, err := NewServiceSyncServer(WithSink(&testSink{name: "test"}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	// open alarms are forwarded and kept active however old the last event
+	alarm := &pb.Alarm{}
+	alarm.SetId(1)
+	alarm.SetUei("uei.opennms.org/test")
+	alarm.SetSeverity(uint32(pb.Severity_MAJOR))
+	alarm.SetLastEventTime(uint64(time.Now().Add(-time.Hour * 24).UnixMilli()))
+	srv.handleAlarms([]instanceAlarm{{alarm: alarm, instanceID: "instance1", now: time.Now()}}, nil)
+
+	if item := <-srv.sinks[0].queue; len(item.alerts) != 1 {
+		t.Fatalf("handleAlarms() sent %d alerts, want 1", len(item.alerts))
+	}
+	if got := srv.active.len(); got != 1 {
+		t.Errorf("active alarms = %d, want 1", got)
+	}
+}
+
+func TestRefreshIntervalClamped(t *testing.T) {
+	srv, err := NewServiceSyncServer(WithRefreshInterval(time.Minute*10), WithResolveTimeout(time.Minute*5))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	if srv.refreshInterval != time.Minute*5/2 {
+		t.Errorf("refreshInterval = %v, want %v", srv.refreshInterval, time.Minute*5/2)
+	}
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+// pendingBatch is the batch of alarms waiting to be flushed by the batch
+// worker, which is shared so it can be inspected via the admin API
+type pendingBatch struct {
+	mu     sync.Mutex
+	alarms []instanceAlarm
+}
+
+func (p *pendingBatch) set(alarms []instanceAlarm) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.alarms = alarms
+}
+
+func (p *pendingBatch) list() []instanceAlarm {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.alarms)
+}
+
+type adminInstance struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	LastHeartbeat *time.Time `json:"last_heartbeat"`
+	Down          bool       `json:"down"`
+	LastSnapshot  *time.Time `json:"last_snapshot"`
+	Alarms        int        `json:"alarms"`
+	Nodes         int        `json:"nodes"`
+}
+
+type adminQueue struct {
+	Depth    int            `json:"depth"`
+	Capacity int            `json:"capacity"`
+	Durable  bool           `json:"durable"`
+	Unacked  int            `json:"unacked"`
+	Pending  []adminPending `json:"pending"`
+	Sinks    []adminSink    `json:"sinks"`
+}
+
+type adminPending struct {
+	InstanceID string    `json:"instance_id"`
+	AlarmID    uint64    `json:"alarm_id,omitempty"`
+	UEI        string    `json:"uei,omitempty"`
+	Severity   string    `json:"severity,omitempty"`
+	Service    string    `json:"service,omitempty"`
+	Received   time.Time `json:"received"`
+}
+
+type adminSink struct {
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+}
+
+type adminAlarm struct {
+	InstanceID   string            `json:"instance_id"`
+	AlarmID      uint64            `json:"alarm_id"`
+	ReductionKey string            `json:"reduction_key,omitempty"`
+	Suppressed   bool              `json:"suppressed,omitempty"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"starts_at"`
+	EndsAt       time.Time         `json:"ends_at"`
+}
+
+type adminService struct {
+	ForeignType    string            `json:"foreign_type"`
+	ForeignSource  string            `json:"foreign_source"`
+	ForeignService string            `json:"foreign_service"`
+	Labels         map[string]string `json:"labels"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	StartsAt       time.Time         `json:"starts_at"`
+	EndsAt         time.Time         `json:"ends_at"`
+}
+
+type adminAlertmanagers struct {
+	SRV        string     `json:"srv,omitempty"`
+	URLs       []string   `json:"urls"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	StaleUntil *time.Time `json:"stale_until,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// AdminHandler returns a handler for the JSON admin API, which must be
+// mounted at "/admin/"
+func (s *ServiceSyncServer) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/instances", s.adminInstances)
+	mux.HandleFunc("GET /admin/queue", s.adminQueue)
+	mux.HandleFunc("GET /admin/alarms", s.adminAlarms)
+	mux.HandleFunc("GET /admin/services", s.adminServices)
+	mux.HandleFunc("GET /admin/alertmanagers", s.adminAlertmanagers)
+	mux.HandleFunc("POST /admin/flush", s.adminFlush)
+
+	return mux
+}
+
+func (s *ServiceSyncServer) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("problem encoding admin response", "error", err)
+	}
+}
+
+// timePtr returns nil for the zero time so it is encoded as null
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+func (s *ServiceSyncServer) adminInstances(w http.ResponseWriter, r *http.Request) {
+	instances := make(map[string]*adminInstance)
+	get := func(id string) *adminInstance {
+		if _, ok := instances[id]; !ok {
+			instances[id] = &adminInstance{ID: id}
+		}
+		return instances[id]
+	}
+
+	for id, state := range s.heartbeats.list() {
+		i := get(id)
+		i.Name = state.name
+		i.LastHeartbeat = timePtr(state.lastSeen)
+		i.Down = state.down
+	}
+	for id, status := range s.inventory.status() {
+		i := get(id)
+		i.Name = cmp.Or(i.Name, status.name)
+		i.Nodes = status.nodes
+	}
+	for _, id := range s.seen.instances() {
+		i := get(id)
+		alarms, snapshot := s.seen.status(id)
+		i.Alarms = alarms
+		i.LastSnapshot = timePtr(snapshot)
+	}
+
+	list := make([]*adminInstance, 0, len(instances))
+	for _, i := range instances {
+		list = append(list, i)
+	}
+	slices.SortFunc(list, func(a, b *adminInstance) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	s.writeJSON(w, list)
+}
+
+func (s *ServiceSyncServer) adminQueue(w http.ResponseWriter, r *http.Request) {
+	queue := adminQueue{
+		Depth:    len(s.alarmQueue),
+		Capacity: cap(s.alarmQueue),
+		Durable:  s.wal != nil,
+		Pending:  make([]adminPending, 0),
+		Sinks:    make([]adminSink, 0, len(s.sinks)),
+	}
+	if s.wal != nil {
+		queue.Unacked = s.wal.depth()
+	}
+
+	for _, ia := range s.pending.list() {
+		p := adminPending{InstanceID: ia.instanceID, Received: ia.now}
+		if ia.alarm != nil {
+			p.AlarmID = ia.alarm.GetId()
+			p.UEI = ia.alarm.GetUei()
+			p.Severity = strings.ToLower(pb.Severity(ia.alarm.GetSeverity()).String())
+		}
+		if ia.service != nil {
+			p.Service = ia.service.foreignService
+		}
+		queue.Pending = append(queue.Pending, p)
+	}
+
+	for _, q := range s.sinks {
+		queue.Sinks = append(queue.Sinks, adminSink{Name: q.sink.Name(), Depth: q.depth()})
+	}
+
+	s.writeJSON(w, queue)
+}
+
+func (s *ServiceSyncServer) adminAlarms(w http.ResponseWriter, r *http.Request) {
+	entries := s.active.list()
+	slices.SortFunc(entries, func(a, b activeAlarmEntry) int {
+		return cmp.Or(
+			strings.Compare(a.instanceID, b.instanceID),
+			cmp.Compare(a.alarmID, b.alarmID),
+		)
+	})
+
+	list := make([]adminAlarm, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, adminAlarm{
+			InstanceID:   e.instanceID,
+			AlarmID:      e.alarmID,
+			ReductionKey: e.reductionKey,
+			Suppressed:   e.suppressed,
+			Labels:       e.alert.Labels,
+			Annotations:  e.alert.Annotations,
+			StartsAt:     e.alert.StartsAt,
+			EndsAt:       e.alert.EndsAt,
+		})
+	}
+
+	s.writeJSON(w, list)
+}
+
+// adminServices lists the unhealthy business services, which are kept apart
+// from alarms as they are not tied to an instance
+func (s *ServiceSyncServer) adminServices(w http.ResponseWriter, r *http.Request) {
+	entries := s.active.listServices()
+	slices.SortFunc(entries, func(a, b activeServiceEntry) int {
+		return cmp.Or(
+			strings.Compare(a.key.foreignType, b.key.foreignType),
+			strings.Compare(a.key.foreignSource, b.key.foreignSource),
+			strings.Compare(a.key.foreignService, b.key.foreignService),
+		)
+	})
+
+	list := make([]adminService, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, adminService{
+			ForeignType:    e.key.foreignType,
+			ForeignSource:  e.key.foreignSource,
+			ForeignService: e.key.foreignService,
+			Labels:         e.alert.Labels,
+			Annotations:    e.alert.Annotations,
+			StartsAt:       e.alert.StartsAt,
+			EndsAt:         e.alert.EndsAt,
+		})
+	}
+
+	s.writeJSON(w, list)
+}
+
+func (s *ServiceSyncServer) adminAlertmanagers(w http.ResponseWriter, r *http.Request) {
+	ams := adminAlertmanagers{URLs: make([]string, 0)}
+
+	switch {
+	case s.srvCache != nil:
+		// only report what is cached rather than triggering a lookup
+		urls, expiresAt, staleUntil := s.srvCache.status()
+		ams.SRV = s.srvName
+		ams.URLs = append(ams.URLs, urls...)
+		ams.ExpiresAt = timePtr(expiresAt)
+		ams.StaleUntil = timePtr(staleUntil)
+	case s.alertmanagers != nil:
+		urls, err := s.alertmanagers()
+		if err != nil {
+			ams.Error = err.Error()
+		}
+		ams.URLs = append(ams.URLs, urls...)
+	}
+
+	s.writeJSON(w, ams)
+}
+
+// adminFlush asks the batch worker to flush the pending batch of alarms
+func (s *ServiceSyncServer) adminFlush(w http.ResponseWriter, r *http.Request) {
+	done := make(chan int, 1)
+
+	select {
+	case s.flushRequests <- done:
+	case <-r.Context().Done():
+		return
+	case <-s.ctx.Done():
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	select {
+	case n := <-done:
+		s.logger.Info("flushed alarms on request", "alarmcount", n)
+		s.writeJSON(w, map[string]int{"flushed": n})
+	case <-r.Context().Done():
+	}
+}
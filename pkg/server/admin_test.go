@@ -0,0 +1,161 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func TestAdminHandler(t *testing.T) {
+	srv, err := NewServiceSyncServer(WithAlertmanagerUrl([]string{"http://am-0:9093"}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	now := time.Now()
+	srv.heartbeats.seen("instance1", "horizon", now)
+	srv.inventory.update("instance1", "horizon", true, []*pb.Node{testNode(1, "router1")})
+	srv.seen.replace("instance1", map[uint64]bool{42: true})
+	srv.active.set("instance1", 42, activeAlarm{alert: testAlert("one"), reductionKey: "uei.opennms.org/nodes/nodeDown::1"})
+	srv.active.setService(activeServiceKey{"business-service", "bsm", "web"}, testAlert("web"))
+	srv.pending.set([]instanceAlarm{{alarm: testAlarm(), instanceID: "instance1", now: now}})
+
+	tests := []struct {
+		name  string
+		path  string
+		check func(t *testing.T, body []byte)
+	}{
+		{"instances", "/admin/instances", func(t *testing.T, body []byte) {
+			var list []adminInstance
+			if err := json.Unmarshal(body, &list); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if len(list) != 1 || list[0].Name != "horizon" || list[0].Nodes != 1 || list[0].Alarms != 1 || list[0].LastHeartbeat == nil || list[0].LastSnapshot == nil {
+				t.Errorf("instances = %+v, want instance1 with heartbeat, snapshot, alarm and node", list)
+			}
+		}},
+		{"queue", "/admin/queue", func(t *testing.T, body []byte) {
+			var queue adminQueue
+			if err := json.Unmarshal(body, &queue); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if queue.Capacity != 100 || len(queue.Pending) != 1 || queue.Pending[0].AlarmID != 42 || queue.Pending[0].Severity != "major" {
+				t.Errorf("queue = %+v, want pending alarm 42", queue)
+			}
+			if len(queue.Sinks) != 1 || queue.Sinks[0].Name != "alertmanager" {
+				t.Errorf("queue sinks = %+v, want alertmanager", queue.Sinks)
+			}
+		}},
+		{"alarms", "/admin/alarms", func(t *testing.T, body []byte) {
+			var list []adminAlarm
+			if err := json.Unmarshal(body, &list); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if len(list) != 1 || list[0].AlarmID != 42 || list[0].ReductionKey != "uei.opennms.org/nodes/nodeDown::1" {
+				t.Errorf("alarms = %+v, want alarm 42", list)
+			}
+		}},
+		{"services", "/admin/services", func(t *testing.T, body []byte) {
+			var list []adminService
+			if err := json.Unmarshal(body, &list); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if len(list) != 1 || list[0].ForeignSource != "bsm" || list[0].ForeignService != "web" || list[0].Labels["alertname"] != "web" {
+				t.Errorf("services = %+v, want service web", list)
+			}
+		}},
+		{"alertmanagers", "/admin/alertmanagers", func(t *testing.T, body []byte) {
+			var ams adminAlertmanagers
+			if err := json.Unmarshal(body, &ams); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if len(ams.URLs) != 1 || ams.URLs[0] != "http://am-0:9093/api/v2/alerts" {
+				t.Errorf("alertmanagers = %+v, want am-0", ams)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			tt.check(t, w.Body.Bytes())
+		})
+	}
+}
+
+func TestAdminAlertmanagersSRV(t *testing.T) {
+	srv, err := NewServiceSyncServer(WithAlertManagerSrv("http", "_alertmanager._tcp.example.com"))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	srv.srvCache.set([]string{"http://am-0:9093/api/v2/alerts"}, time.Minute)
+
+	w := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/alertmanagers", nil))
+
+	var ams adminAlertmanagers
+	if err := json.Unmarshal(w.Body.Bytes(), &ams); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if ams.SRV != "_alertmanager._tcp.example.com" || len(ams.URLs) != 1 || ams.ExpiresAt == nil {
+		t.Errorf("alertmanagers = %+v, want cached SRV targets", ams)
+	}
+}
+
+func TestAdminFlush(t *testing.T) {
+	srv, err := NewServiceSyncServer(WithSink(&testSink{name: "test"}), WithBatchMaxWait(time.Hour))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.batchWorker()
+	}()
+	defer func() {
+		srv.Shutdown()
+		<-done
+	}()
+
+	alarm := testAlarm()
+	alarm.SetLastEventTime(uint64(time.Now().UnixMilli()))
+	srv.alarmQueue <- alarmBatch{instanceID: "instance1", alarms: []instanceAlarm{{alarm: alarm, instanceID: "instance1", now: time.Now()}}}
+
+	// wait for the batch worker to pick up the alarm
+	deadline := time.Now().Add(time.Second * 5)
+	for len(srv.pending.list()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("alarm was not added to the pending batch")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	w := httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/flush", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "{\"flushed\":1}\n" {
+		t.Errorf("flush = %d %q, want 1 alarm flushed", w.Code, w.Body.String())
+	}
+	if alerts := receive(t, srv.sinks[0]); len(alerts) != 1 {
+		t.Errorf("flush sent %d alerts, want 1", len(alerts))
+	}
+	if got := len(srv.pending.list()); got != 0 {
+		t.Errorf("pending after flush = %d, want 0", got)
+	}
+
+	// only POST is allowed
+	w = httptest.NewRecorder()
+	srv.AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/flush", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET flush status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+// ProblemWithoutClearConfig sets when PROBLEM_WITHOUT_CLEAR alarms, which
+// OpenNMS never clears, are resolved
+type ProblemWithoutClearConfig struct {
+	// ResolveAfter resolves alarms this long after their last event. When
+	// zero alarms keep firing until they are deleted.
+	ResolveAfter time.Duration `yaml:"resolve_after"`
+
+	// UEIs overrides ResolveAfter for alarms with these UEIs
+	UEIs map[string]time.Duration `yaml:"ueis"`
+}
+
+func (c ProblemWithoutClearConfig) validate() error {
+	if c.ResolveAfter < 0 {
+		return fmt.Errorf("invalid resolve_after: %s", c.ResolveAfter)
+	}
+
+	for uei, d := range c.UEIs {
+		if d < 0 {
+			return fmt.Errorf("invalid duration for %s: %s", uei, d)
+		}
+	}
+
+	return nil
+}
+
+// resolveAfter returns the duration after which an alarm with the UEI is
+// resolved, or zero if it is not
+func (c ProblemWithoutClearConfig) resolveAfter(uei string) time.Duration {
+	if d, ok := c.UEIs[uei]; ok {
+		return d
+	}
+
+	return c.ResolveAfter
+}
+
+func alarmType(alarm *pb.Alarm) pb.Alarm_Type {
+	return pb.Alarm_Type(alarm.GetType())
+}
+
+// clearAlarm resolves the active problem alarm whose reduction key matches
+// the clear key of a CLEAR alarm
+func (s *ServiceSyncServer) clearAlarm(instanceID string, alarm *pb.Alarm) (Alert, bool) {
+	// the clear alarm itself is never forwarded
+	s.active.delete(instanceID, alarm.GetId())
+
+	if alarm.GetClearKey() == "" {
+		return Alert{}, false
+	}
+
+	alert, ok := s.active.clear(instanceID, alarm.GetClearKey())
+	if !ok {
+		return Alert{}, false
+	}
+
+	alert.EndsAt = time.Now()
+	if t := alarm.GetLastEventTime(); t != 0 {
+		alert.EndsAt = time.UnixMilli(int64(t))
+	}
+
+	return alert, true
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func TestProblemWithoutClear(t *testing.T) {
+	last := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+
+	tests := []struct {
+		name       string
+		config     ProblemWithoutClearConfig
+		alarmType  pb.Alarm_Type
+		wantEndsAt time.Time
+		wantActive int
+	}{
+		{"not configured", ProblemWithoutClearConfig{}, pb.Alarm_PROBLEM_WITHOUT_CLEAR, time.Time{}, 1},
+		{"default", ProblemWithoutClearConfig{ResolveAfter: time.Hour}, pb.Alarm_PROBLEM_WITHOUT_CLEAR, last.Add(time.Hour), 0},
+		{"uei override", ProblemWithoutClearConfig{ResolveAfter: time.Hour, UEIs: map[string]time.Duration{"uei.opennms.org/nodes/nodeDown": time.Minute * 10}}, pb.Alarm_PROBLEM_WITHOUT_CLEAR, last.Add(time.Minute * 10), 0},
+		{"uei disabled", ProblemWithoutClearConfig{ResolveAfter: time.Hour, UEIs: map[string]time.Duration{"uei.opennms.org/nodes/nodeDown": 0}}, pb.Alarm_PROBLEM_WITHOUT_CLEAR, time.Time{}, 1},
+		{"problem with clear", ProblemWithoutClearConfig{ResolveAfter: time.Hour}, pb.Alarm_PROBLEM_WITH_CLEAR, time.Time{}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithConfig(&Config{ProblemWithoutClear: tt.config}), WithSink(&testSink{name: "test"}))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			alarm := testAlarm()
+			alarm.SetType(uint32(tt.alarmType))
+			alarm.SetLastEventTime(uint64(last.UnixMilli()))
+			srv.handleAlarms([]instanceAlarm{{alarm: alarm, instanceID: "instance1", now: time.Now()}}, nil)
+
+			alerts := receive(t, srv.sinks[0])
+			if len(alerts) != 1 {
+				t.Fatalf("handleAlarms() sent %d alerts, want 1", len(alerts))
+			}
+
+			// alarms left active end at now plus the resolve timeout
+			if !tt.wantEndsAt.IsZero() && !alerts[0].EndsAt.Equal(tt.wantEndsAt) {
+				t.Errorf("EndsAt = %v, want %v", alerts[0].EndsAt, tt.wantEndsAt)
+			}
+			if tt.wantEndsAt.IsZero() && alerts[0].EndsAt.Before(time.Now()) {
+				t.Errorf("EndsAt = %v, want after now", alerts[0].EndsAt)
+			}
+			if got := srv.active.len(); got != tt.wantActive {
+				t.Errorf("active alarms = %d, want %d", got, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestClearAlarm(t *testing.T) {
+	last := time.Now().Truncate(time.Millisecond)
+
+	tests := []struct {
+		name       string
+		instanceID string
+		clearKey   string
+		wantAlerts int
+		wantActive int
+	}{
+		{"matching", "instance1", "uei.opennms.org/nodes/nodeDown::1", 1, 0},
+		{"other reduction key", "instance1", "uei.opennms.org/nodes/nodeDown::2", 0, 1},
+		{"other instance", "instance2", "uei.opennms.org/nodes/nodeDown::1", 0, 1},
+		{"no clear key", "instance1", "", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithSink(&testSink{name: "test"}))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			problem := testAlarm()
+			problem.SetLastEventTime(uint64(time.Now().UnixMilli()))
+			srv.handleAlarms([]instanceAlarm{{alarm: problem, instanceID: "instance1", now: time.Now()}}, nil)
+			if alerts := receive(t, srv.sinks[0]); len(alerts) != 1 {
+				t.Fatalf("handleAlarms() sent %d alerts for problem, want 1", len(alerts))
+			}
+
+			clear := &pb.Alarm{}
+			clear.SetId(43)
+			clear.SetUei("uei.opennms.org/nodes/nodeUp")
+			clear.SetType(uint32(pb.Alarm_CLEAR))
+			clear.SetSeverity(uint32(pb.Severity_NORMAL))
+			clear.SetClearKey(tt.clearKey)
+			clear.SetLastEventTime(uint64(last.UnixMilli()))
+			srv.handleAlarms([]instanceAlarm{{alarm: clear, instanceID: tt.instanceID, now: time.Now()}}, nil)
+
+			alerts := receive(t, srv.sinks[0])
+			if len(alerts) != tt.wantAlerts {
+				t.Fatalf("handleAlarms() sent %d alerts for clear, want %d", len(alerts), tt.wantAlerts)
+			}
+			if tt.wantAlerts > 0 {
+				// the problem alert is resolved rather than the clear being forwarded
+				if alerts[0].Labels["alertname"] != "uei.opennms.org/nodes/nodeDown" {
+					t.Errorf("resolved alert labels = %v, want problem alert", alerts[0].Labels)
+				}
+				if !alerts[0].EndsAt.Equal(last) {
+					t.Errorf("EndsAt = %v, want %v", alerts[0].EndsAt, last)
+				}
+			}
+			if got := srv.active.len(); got != tt.wantActive {
+				t.Errorf("active alarms = %d, want %d", got, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestProblemWithoutClearInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ProblemWithoutClearConfig
+	}{
+		{"negative default", ProblemWithoutClearConfig{ResolveAfter: -time.Minute}},
+		{"negative uei", ProblemWithoutClearConfig{UEIs: map[string]time.Duration{"uei": -time.Minute}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServiceSyncServer(WithConfig(&Config{ProblemWithoutClear: tt.config})); err == nil {
+				t.Error("NewServiceSyncServer() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// alertmanagerSink is the built-in Sink that sends alerts to the configured
+// Alertmanager(s)
+type alertmanagerSink struct {
+	srv *ServiceSyncServer
+}
+
+func (a *alertmanagerSink) Name() string {
+	return "alertmanager"
+}
+
+// Send posts the alerts to every Alertmanager and only fails if no
+// Alertmanager accepted the alerts
+func (a *alertmanagerSink) Send(ctx context.Context, alerts []Alert) error {
+	s := a.srv
+
+	ams, err := s.alertmanagers()
+	if err != nil {
+		s.amLookupErrors.Inc()
+		return err
+	}
+
+	payload, err := json.Marshal(postableAlerts(alerts))
+	if err != nil {
+		return err
+	}
+
+	logger := s.logger.With("count", len(alerts))
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for _, am := range ams {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+
+			s.alertmanagerTotal.WithLabelValues(url).Inc()
+
+			if err := a.post(ctx, url, payload, logger); err != nil {
+				s.alertmanagerErrors.WithLabelValues(url).Inc()
+				return
+			}
+
+			accepted.Add(1)
+		}(am)
+	}
+	wg.Wait()
+
+	if len(ams) > 0 && accepted.Load() == 0 {
+		return fmt.Errorf("no alertmanager accepted %d alerts", len(alerts))
+	}
+
+	return nil
+}
+
+// post sends the payload to a single Alertmanager, retrying temporary
+// failures with backoff until the retry limit is reached, the circuit breaker
+// for the Alertmanager opens or ctx is done. A request is still made once ctx
+// is done so batches are delivered on shutdown, however it is not retried.
+func (a *alertmanagerSink) post(ctx context.Context, url string, payload []byte, logger *slog.Logger) error {
+	s := a.srv
+
+	cb := s.breakers.get(url)
+	defer func() {
+		s.alertmanagerBreaker.WithLabelValues(url).Set(float64(cb.current()))
+	}()
+
+	for attempt := 0; ; attempt++ {
+		if !cb.allow(time.Now()) {
+			logger.Warn("circuit breaker open for alertmanager", "url", url)
+			return errBreakerOpen
+		}
+
+		if attempt > 0 {
+			s.alertmanagerRetries.WithLabelValues(url).Inc()
+		}
+
+		retry, err := a.postOnce(ctx, url, payload, logger)
+		if err == nil {
+			cb.success()
+			return nil
+		}
+
+		// the alertmanager is reachable but rejected the request
+		if !retry {
+			cb.success()
+			return err
+		}
+
+		cb.failure(time.Now())
+		if attempt >= s.retries {
+			return err
+		}
+
+		delay := backoff(attempt, s.retryBackoff, s.retryMaxBackoff)
+		logger.Debug("retrying send to alertmanager", "url", url, "attempt", attempt+1, "delay", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// postOnce makes a single request to an Alertmanager and reports whether a
+// failed request may be retried. Connection errors, 429 and 5xx responses are
+// retried, however any other 4xx response is not. The request is bounded by
+// the send timeout rather than the cancellation of ctx.
+func (a *alertmanagerSink) postOnce(ctx context.Context, url string, payload []byte, logger *slog.Logger) (bool, error) {
+	s := a.srv
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		logger.Warn("error creating request", "url", url, "error", err)
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		logger.Warn("error sending to alertmanager", "url", url, "error", err)
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Warn("bad status code from alertmanager", "url", url, "status", resp.Status)
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return retry, fmt.Errorf("bad status code from alertmanager: %s", resp.Status)
+	}
+
+	logger.Info("sent to alertmanager", "url", url, "status", resp.Status)
+
+	return false, nil
+}
+
+// alertmanagerAPIs returns the base API URLs of the Alertmanagers
+func (s *ServiceSyncServer) alertmanagerAPIs() ([]string, error) {
+	ams, err := s.alertmanagers()
+	if err != nil {
+		s.amLookupErrors.Inc()
+		return nil, err
+	}
+
+	list := make([]string, 0, len(ams))
+	for _, am := range ams {
+		list = append(list, strings.TrimSuffix(am, "/alerts"))
+	}
+
+	return list, nil
+}
+
+// postableAlerts converts alerts to the Alertmanager API v2 model
+func postableAlerts(alerts []Alert) []*models.PostableAlert {
+	list := make([]*models.PostableAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		post := &models.PostableAlert{
+			Alert: models.Alert{
+				Labels:       alert.Labels,
+				GeneratorURL: strfmt.URI(alert.GeneratorURL),
+			},
+			Annotations: alert.Annotations,
+			StartsAt:    strfmt.DateTime(alert.StartsAt),
+			EndsAt:      strfmt.DateTime(alert.EndsAt),
+		}
+		list = append(list, post)
+	}
+
+	return list
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retries      int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{"ok", []int{http.StatusOK}, 3, false, 1},
+		{"retry server error", []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, 3, false, 3},
+		{"retry too many requests", []int{http.StatusTooManyRequests, http.StatusOK}, 3, false, 2},
+		{"no retry client error", []int{http.StatusBadRequest, http.StatusOK}, 3, true, 1},
+		{"retries exhausted", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK}, 1, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := requests.Add(1)
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer ts.Close()
+
+			srv, err := NewServiceSyncServer(
+				WithRetries(tt.retries),
+				WithRetryBackoff(time.Millisecond, time.Millisecond),
+			)
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+			am := &alertmanagerSink{srv: srv}
+
+			err = am.post(context.Background(), ts.URL, []byte("[]"), srv.logger)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("post() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("post() made %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestPostBreakerOpen(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	srv, err := NewServiceSyncServer(
+		WithRetries(5),
+		WithRetryBackoff(time.Millisecond, time.Millisecond),
+		WithCircuitBreaker(2, time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	am := &alertmanagerSink{srv: srv}
+
+	// retries stop once the breaker opens
+	if err := am.post(context.Background(), ts.URL, []byte("[]"), srv.logger); err != errBreakerOpen {
+		t.Errorf("post() error = %v, want %v", err, errBreakerOpen)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("post() made %d requests, want 2", got)
+	}
+
+	// no requests are made while the breaker is open
+	if err := am.post(context.Background(), ts.URL, []byte("[]"), srv.logger); err != errBreakerOpen {
+		t.Errorf("post() error = %v, want %v", err, errBreakerOpen)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("post() made %d requests, want 2", got)
+	}
+}
+
+func TestPostCancelled(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"delivered", http.StatusOK, false},
+		{"not retried", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			srv, err := NewServiceSyncServer(WithRetries(3), WithRetryBackoff(time.Minute, time.Minute))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+			am := &alertmanagerSink{srv: srv}
+
+			// a cancelled context still makes one request but does not retry
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err = am.post(ctx, ts.URL, []byte("[]"), srv.logger)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("post() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := requests.Load(); got != 1 {
+				t.Errorf("post() made %d requests, want 1", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+var errBreakerOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (b breakerState) String() string {
+	switch b {
+	case breakerClosed:
+		return "closed"
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+
+	return "unknown"
+}
+
+// circuitBreaker stops requests to a target after a number of consecutive
+// failures until a cooldown has passed, at which point a single trial
+// request is allowed through
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     breakerState
+	openedAt  time.Time
+	trial     bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a request may be made to the target
+func (b *circuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trial = true
+
+		return true
+	case breakerHalfOpen:
+		// only a single trial request is allowed at a time
+		if b.trial {
+			return false
+		}
+		b.trial = true
+
+		return true
+	}
+
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.state = breakerClosed
+	b.trial = false
+}
+
+func (b *circuitBreaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// breakers holds a circuit breaker per target
+type breakers struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	targets   map[string]*circuitBreaker
+}
+
+func newBreakers(threshold int, cooldown time.Duration) *breakers {
+	return &breakers{
+		threshold: threshold,
+		cooldown:  cooldown,
+		targets:   make(map[string]*circuitBreaker),
+	}
+}
+
+func (b *breakers) get(target string) *circuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb, ok := b.targets[target]
+	if !ok {
+		cb = newCircuitBreaker(b.threshold, b.cooldown)
+		b.targets[target] = cb
+	}
+
+	return cb
+}
+
+// backoff returns a random delay between zero and the exponential backoff
+// for the attempt, capped at limit ("full jitter")
+func backoff(attempt int, base, limit time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+
+	if d <= 0 {
+		return 0
+	}
+
+	return rand.N(d)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(2, time.Minute)
+
+	if !cb.allow(now) {
+		t.Fatal("allow() = false for new breaker, want true")
+	}
+
+	// breaker opens after reaching the threshold
+	cb.failure(now)
+	if got := cb.current(); got != breakerClosed {
+		t.Errorf("current() = %s after one failure, want closed", got)
+	}
+	cb.failure(now)
+	if got := cb.current(); got != breakerOpen {
+		t.Errorf("current() = %s after two failures, want open", got)
+	}
+	if cb.allow(now.Add(time.Second)) {
+		t.Error("allow() = true during cooldown, want false")
+	}
+
+	// a single trial is allowed after the cooldown
+	if !cb.allow(now.Add(time.Minute)) {
+		t.Error("allow() = false after cooldown, want true")
+	}
+	if got := cb.current(); got != breakerHalfOpen {
+		t.Errorf("current() = %s after cooldown, want half-open", got)
+	}
+	if cb.allow(now.Add(time.Minute)) {
+		t.Error("allow() = true during trial, want false")
+	}
+
+	// a failed trial opens the breaker again
+	cb.failure(now.Add(time.Minute))
+	if got := cb.current(); got != breakerOpen {
+		t.Errorf("current() = %s after failed trial, want open", got)
+	}
+
+	// a successful trial closes the breaker
+	if !cb.allow(now.Add(time.Minute * 2)) {
+		t.Error("allow() = false after cooldown, want true")
+	}
+	cb.success()
+	if got := cb.current(); got != breakerClosed {
+		t.Errorf("current() = %s after successful trial, want closed", got)
+	}
+	if !cb.allow(now.Add(time.Minute * 2)) {
+		t.Error("allow() = false for closed breaker, want true")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	now := time.Now()
+	cb := newCircuitBreaker(0, time.Minute)
+
+	for range 10 {
+		cb.failure(now)
+	}
+	if !cb.allow(now) {
+		t.Error("allow() = false for disabled breaker, want true")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		base    time.Duration
+		limit   time.Duration
+		want    time.Duration
+	}{
+		{"first", 0, time.Second, time.Minute, time.Second},
+		{"second", 1, time.Second, time.Minute, time.Second * 2},
+		{"third", 2, time.Second, time.Minute, time.Second * 4},
+		{"capped", 10, time.Second, time.Second * 5, time.Second * 5},
+		{"zero", 3, 0, time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				got := backoff(tt.attempt, tt.base, tt.limit)
+				if got < 0 || (tt.want > 0 && got >= tt.want) || (tt.want == 0 && got != 0) {
+					t.Fatalf("backoff() = %v, want [0, %v)", got, tt.want)
+				}
+			}
+		})
+	}
+}
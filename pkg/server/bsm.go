@@ -0,0 +1,177 @@
+package server
+
+import (
+	"io"
+	"time"
+
+	"github.com/andrewheberle/onms-grpc-receiver/pkg/bsm"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// BSMServer implements the Business Service Monitoring (BSM) ServiceSync gRPC
+// API using the same send and batching pipeline as ServiceSyncServer
+type BSMServer struct {
+	*ServiceSyncServer
+
+	// metrics
+	stateTotal *prometheus.CounterVec
+
+	bsm.UnimplementedServiceSyncServer
+}
+
+// serviceState is a single business service state from a StateUpdateList
+type serviceState struct {
+	foreignType    string
+	foreignSource  string
+	foreignService string
+	healthy        bool
+}
+
+func NewBSMServer(opts ...ServiceSyncServerOption) (*BSMServer, error) {
+	srv, err := NewServiceSyncServer(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BSMServer{
+		ServiceSyncServer: srv,
+	}
+
+	// set up metrics
+	s.stateTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_bsm_state_total",
+		Help: "Total number of business service state updates seen.",
+	},
+		[]string{"foreign_type", "foreign_source"})
+
+	// register metrics
+	s.registry.MustRegister(
+		s.stateTotal,
+	)
+
+	return s, nil
+}
+
+// InventoryUpdate simply accepts and discards any data to avoid errors on the Horizon side
+func (s *BSMServer) InventoryUpdate(stream grpc.BidiStreamingServer[bsm.InventoryUpdateList, emptypb.Empty]) error {
+	return discard(stream)
+}
+
+func (s *BSMServer) StateUpdate(stream grpc.BidiStreamingServer[bsm.StateUpdateList, emptypb.Empty]) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		foreignType := in.GetForeignType()
+		foreignSource := in.GetForeignSource()
+		updates := in.GetUpdates()
+
+		s.stateTotal.WithLabelValues(foreignType, foreignSource).Inc()
+
+		s.logger.Info("StateUpdate",
+			"foreign_type", foreignType,
+			"foreign_source", foreignSource,
+			"updatecount", len(updates),
+		)
+
+		// wrap states before enqueuing
+		wrapped := make([]instanceAlarm, 0, len(updates))
+		for _, update := range updates {
+			wrapped = append(wrapped, instanceAlarm{
+				service: &serviceState{
+					foreignType:    foreignType,
+					foreignSource:  foreignSource,
+					foreignService: update.GetForeignService(),
+					healthy:        update.GetHealthy(),
+				},
+				now: time.Now(),
+			})
+		}
+
+		if err := s.enqueue(alarmBatch{
+			foreignSource: foreignSource,
+			alarms:        wrapped,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *BSMServer) HeartBeatUpdate(stream grpc.BidiStreamingServer[bsm.HeartBeat, emptypb.Empty]) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		s.heartbeat(in.GetMonitoringInstance().GetInstanceId(), in.GetMonitoringInstance().GetInstanceName(), in.GetMessage(), in.GetTimestamp())
+	}
+}
+
+// serviceAlert converts a business service state into an alert. Unhealthy
+// services are firing until the resolve timeout and healthy services are
+// resolved immediately.
+func (s *ServiceSyncServer) serviceAlert(ia instanceAlarm) (Alert, bool) {
+	state := ia.service
+
+	if len(s.sinks) == 0 || s.verbose {
+		s.logger.Info("StateUpdate",
+			"foreign_type", state.foreignType,
+			"foreign_source", state.foreignSource,
+			"foreign_service", state.foreignService,
+			"healthy", state.healthy,
+		)
+
+		// finish here if no sinks are configured
+		if len(s.sinks) == 0 {
+			return Alert{}, false
+		}
+	}
+
+	labels := map[string]string{
+		"alertname":       "OpenNMSServiceUnhealthy",
+		"foreign_type":    state.foreignType,
+		"foreign_source":  state.foreignSource,
+		"foreign_service": state.foreignService,
+	}
+
+	alert := Alert{
+		Labels:   labels,
+		StartsAt: ia.now,
+		EndsAt:   ia.now.Add(s.resolveTimeout),
+	}
+
+	// resolve healthy services
+	if state.healthy {
+		alert.EndsAt = ia.now
+	}
+
+	return alert, true
+}
+
+// updateService tracks unhealthy business services in the active table so
+// they are refreshed until healthy, keeping the original start time of the
+// alert, and returns the alert to send
+func (s *ServiceSyncServer) updateService(state *serviceState, alert Alert) Alert {
+	k := activeServiceKey{state.foreignType, state.foreignSource, state.foreignService}
+
+	if !state.healthy {
+		return s.active.setService(k, alert)
+	}
+
+	if v, ok := s.active.popService(k); ok {
+		alert.StartsAt = v.StartsAt
+	}
+
+	return alert
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServiceAlert(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		healthy      bool
+		alertmanager bool
+		wantOK       bool
+		wantEndsAt   time.Time
+	}{
+		{"unhealthy", false, true, true, now.Add(time.Minute * 5)},
+		{"healthy", true, true, true, now},
+		{"no alertmanager", false, false, false, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []ServiceSyncServerOption{}
+			if tt.alertmanager {
+				opts = append(opts, WithAlertmanagerUrl([]string{"http://am:9093"}))
+			}
+			srv, err := NewServiceSyncServer(opts...)
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			got, ok := srv.serviceAlert(instanceAlarm{
+				service: &serviceState{
+					foreignType:    "type",
+					foreignSource:  "source",
+					foreignService: "service",
+					healthy:        tt.healthy,
+				},
+				now: now,
+			})
+			if ok != tt.wantOK {
+				t.Fatalf("serviceAlert() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+
+			for k, v := range map[string]string{"foreign_type": "type", "foreign_source": "source", "foreign_service": "service"} {
+				if got.Labels[k] != v {
+					t.Errorf("serviceAlert() label %s = %q, want %q", k, got.Labels[k], v)
+				}
+			}
+
+			if !got.EndsAt.Equal(tt.wantEndsAt) {
+				t.Errorf("serviceAlert() EndsAt = %v, want %v", got.EndsAt, tt.wantEndsAt)
+			}
+		})
+	}
+}
+
+func TestUpdateService(t *testing.T) {
+	srv, err := NewServiceSyncServer(WithAlertmanagerUrl([]string{"http://am:9093"}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	start := time.Now()
+	update := func(now time.Time, healthy bool) Alert {
+		ia := instanceAlarm{
+			service: &serviceState{
+				foreignType:    "type",
+				foreignSource:  "source",
+				foreignService: "service",
+				healthy:        healthy,
+			},
+			now: now,
+		}
+
+		alert, ok := srv.serviceAlert(ia)
+		if !ok {
+			t.Fatal("serviceAlert() ok = false, want true")
+		}
+
+		return srv.updateService(ia.service, alert)
+	}
+
+	update(start, false)
+	if got := update(start.Add(time.Minute), false); !got.StartsAt.Equal(start) {
+		t.Errorf("StartsAt of repeated unhealthy state = %v, want %v", got.StartsAt, start)
+	}
+
+	// unhealthy services are refreshed
+	refreshed := srv.active.refresh(start.Add(time.Minute*2), srv.resolveTimeout)
+	if len(refreshed) != 1 || !refreshed[0].EndsAt.Equal(start.Add(time.Minute*7)) {
+		t.Fatalf("refresh() = %v, want one alert ending at %v", refreshed, start.Add(time.Minute*7))
+	}
+
+	healthy := start.Add(time.Minute * 3)
+	got := update(healthy, true)
+	if !got.StartsAt.Equal(start) || !got.EndsAt.Equal(healthy) {
+		t.Errorf("healthy alert = %v to %v, want %v to %v", got.StartsAt, got.EndsAt, start, healthy)
+	}
+	if srv.active.len() != 0 {
+		t.Errorf("active.len() = %d, want 0", srv.active.len())
+	}
+
+	// a service that becomes unhealthy again starts a new alert
+	again := start.Add(time.Minute * 4)
+	if got := update(again, false); !got.StartsAt.Equal(again) {
+		t.Errorf("StartsAt after recovery = %v, want %v", got.StartsAt, again)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+// CategoryConfig controls how node categories from the inventory are added
+// to alerts
+type CategoryConfig struct {
+	// Allow lists the categories that may be added as labels. Categories are
+	// not added when this is empty.
+	Allow []string `yaml:"allow"`
+
+	// Mode is either "joined" to add a single label containing all matching
+	// categories or "labels" to add a boolean label per category
+	Mode string `yaml:"mode"`
+
+	// Label is the name of the label used in joined mode
+	Label string `yaml:"label"`
+
+	// Separator is used between categories in joined mode
+	Separator string `yaml:"separator"`
+
+	// Prefix is added to the name of each label in labels mode
+	Prefix string `yaml:"prefix"`
+}
+
+const (
+	categoryModeJoined = "joined"
+	categoryModeLabels = "labels"
+)
+
+var invalidLabelCharsRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// categoryLabels adds labels for allowed node categories
+type categoryLabels struct {
+	allow     map[string]string
+	mode      string
+	label     string
+	separator string
+}
+
+func newCategoryLabels(c CategoryConfig) (*categoryLabels, error) {
+	if len(c.Allow) == 0 {
+		return nil, nil
+	}
+
+	cl := &categoryLabels{
+		allow:     make(map[string]string, len(c.Allow)),
+		mode:      c.Mode,
+		label:     c.Label,
+		separator: c.Separator,
+	}
+	if cl.mode == "" {
+		cl.mode = categoryModeJoined
+	}
+	if cl.label == "" {
+		cl.label = "categories"
+	}
+	if cl.separator == "" {
+		cl.separator = ","
+	}
+
+	switch cl.mode {
+	case categoryModeJoined:
+		if !labelNameRegexp.MatchString(cl.label) || reservedLabels[cl.label] {
+			return nil, fmt.Errorf("invalid label %q", cl.label)
+		}
+	case categoryModeLabels:
+	default:
+		return nil, fmt.Errorf("unknown mode %q", c.Mode)
+	}
+
+	for _, category := range c.Allow {
+		name := c.Prefix + invalidLabelCharsRegexp.ReplaceAllString(category, "_")
+		if cl.mode == categoryModeLabels && (!labelNameRegexp.MatchString(name) || reservedLabels[name]) {
+			return nil, fmt.Errorf("invalid label %q for category %q", name, category)
+		}
+		cl.allow[category] = name
+	}
+
+	return cl, nil
+}
+
+// apply adds labels for any allowed categories of the node
+func (c *categoryLabels) apply(node *pb.Node, labels map[string]string) {
+	if c == nil || node == nil {
+		return
+	}
+
+	matched := make([]string, 0)
+	for _, category := range node.GetCategory() {
+		name, ok := c.allow[category]
+		if !ok {
+			continue
+		}
+
+		if c.mode == categoryModeLabels {
+			labels[name] = "true"
+			continue
+		}
+
+		matched = append(matched, category)
+	}
+
+	if len(matched) > 0 {
+		slices.Sort(matched)
+		labels[c.label] = strings.Join(slices.Compact(matched), c.separator)
+	}
+}
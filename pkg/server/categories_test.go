@@ -0,0 +1,47 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func TestCategoryLabels(t *testing.T) {
+	node := &pb.Node{}
+	node.SetCategory([]string{"Servers", "Production", "Dev Team", "Unlisted"})
+
+	tests := []struct {
+		name    string
+		config  CategoryConfig
+		want    map[string]string
+		wantErr bool
+	}{
+		{"disabled", CategoryConfig{}, map[string]string{}, false},
+		{"joined", CategoryConfig{Allow: []string{"Production", "Servers", "Routers"}}, map[string]string{"categories": "Production,Servers"}, false},
+		{"joined custom", CategoryConfig{Allow: []string{"Production", "Servers"}, Label: "onms_categories", Separator: "|"}, map[string]string{"onms_categories": "Production|Servers"}, false},
+		{"labels", CategoryConfig{Allow: []string{"Production", "Dev Team", "Routers"}, Mode: "labels", Prefix: "category_"}, map[string]string{"category_Production": "true", "category_Dev_Team": "true"}, false},
+		{"no match", CategoryConfig{Allow: []string{"Routers"}}, map[string]string{}, false},
+		{"unknown mode", CategoryConfig{Allow: []string{"Routers"}, Mode: "other"}, nil, true},
+		{"invalid label", CategoryConfig{Allow: []string{"Routers"}, Label: "bad-label"}, nil, true},
+		{"reserved label", CategoryConfig{Allow: []string{"Routers"}, Label: "instance_id"}, nil, true},
+		{"invalid category label", CategoryConfig{Allow: []string{"1st"}, Mode: "labels"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl, err := newCategoryLabels(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newCategoryLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got := make(map[string]string)
+			cl.apply(node, got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
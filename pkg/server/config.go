@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Config is the alert configuration that is loaded from a YAML file
+type Config struct {
+	// Labels maps label names to alarm fields, which are merged with the
+	// default labels. Mapping a label to an empty field removes it.
+	Labels map[string]string `yaml:"labels"`
+
+	// NodeLabels maps label names to fields of the node from the inventory
+	NodeLabels map[string]string `yaml:"node_labels"`
+
+	// InterfaceLabels adds the name, alias and speed of the SNMP interface
+	// from the inventory for alarms with an ifIndex
+	InterfaceLabels bool `yaml:"interface_labels"`
+
+	// Categories controls the labels added for node categories
+	Categories CategoryConfig `yaml:"categories"`
+
+	// Annotations maps annotation names to alarm fields
+	Annotations map[string]string `yaml:"annotations"`
+
+	// Templates maps annotation names to text/template definitions, which
+	// take precedence over Annotations
+	Templates map[string]string `yaml:"templates"`
+
+	// EventRules turn matching events into alerts
+	EventRules []EventRule `yaml:"event_rules"`
+
+	// EventMetrics limits the UEIs used as labels for event metrics
+	EventMetrics EventMetricsConfig `yaml:"event_metrics"`
+
+	// ProblemWithoutClear sets when PROBLEM_WITHOUT_CLEAR alarms resolve
+	ProblemWithoutClear ProblemWithoutClearConfig `yaml:"problem_without_clear"`
+
+	// Situations controls how correlated situations are sent
+	Situations SituationConfig `yaml:"situations"`
+
+	// RelabelConfigs are run in order against the labels of each alert
+	RelabelConfigs []RelabelConfig `yaml:"alert_relabel_configs"`
+}
+
+// LoadConfig reads and parses the configuration file at path
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseConfig(b)
+}
+
+// ParseConfig parses a YAML configuration, rejecting any unknown keys
+func ParseConfig(b []byte) (*Config, error) {
+	cfg := &Config{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,411 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+// EventRule turns matching events into alerts
+type EventRule struct {
+	// Name is used as the alertname, which defaults to the UEI of the event
+	Name string `yaml:"name"`
+
+	// UEI is a regular expression that must match the whole UEI
+	UEI string `yaml:"uei"`
+
+	// Severities limits matches to events with these severities
+	Severities []string `yaml:"severities"`
+
+	// Params maps event parameter names to regular expressions that must
+	// match the whole value
+	Params map[string]string `yaml:"params"`
+
+	// Labels and Annotations map names to event parameter names
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	Resolve EventResolve `yaml:"resolve"`
+}
+
+// EventResolve controls how alerts from events are resolved. At least one of
+// Timeout or ClearUEI must be set.
+type EventResolve struct {
+	// Timeout resolves the alert after a fixed duration
+	Timeout time.Duration `yaml:"timeout"`
+
+	// ClearUEI resolves the alert when an event with this UEI is received
+	// for the same node and with the same value of CorrelateParam
+	ClearUEI       string `yaml:"clear_uei"`
+	CorrelateParam string `yaml:"correlate_param"`
+
+	// MaxAge limits how long an alert without a timeout waits for a clear
+	// event, after which it is no longer refreshed and resolves. This
+	// defaults to 24h.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// defaultEventMaxAge is how long alerts wait for a clear event by default
+const defaultEventMaxAge = time.Hour * 24
+
+type eventRule struct {
+	index      int
+	name       string
+	uei        *regexp.Regexp
+	severities map[string]bool
+	params     map[string]*regexp.Regexp
+	labels     map[string]string
+	annotation map[string]string
+	timeout    time.Duration
+	clearUEI   string
+	correlate  string
+	maxAge     time.Duration
+}
+
+func newEventRules(rules []EventRule) ([]eventRule, error) {
+	compiled := make([]eventRule, 0, len(rules))
+	for n, r := range rules {
+		if r.UEI == "" {
+			return nil, fmt.Errorf("rule %d: uei is required", n)
+		}
+		uei, err := regexp.Compile("^(?:" + r.UEI + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid uei: %w", n, err)
+		}
+
+		if r.Resolve.Timeout <= 0 && r.Resolve.ClearUEI == "" {
+			return nil, fmt.Errorf("rule %d: resolve timeout or clear_uei is required", n)
+		}
+		if r.Resolve.MaxAge < 0 {
+			return nil, fmt.Errorf("rule %d: resolve max_age must not be negative", n)
+		}
+
+		er := eventRule{
+			index:      n,
+			name:       r.Name,
+			uei:        uei,
+			severities: make(map[string]bool, len(r.Severities)),
+			params:     make(map[string]*regexp.Regexp, len(r.Params)),
+			labels:     r.Labels,
+			annotation: r.Annotations,
+			timeout:    r.Resolve.Timeout,
+			clearUEI:   r.Resolve.ClearUEI,
+			correlate:  r.Resolve.CorrelateParam,
+			maxAge:     cmp.Or(r.Resolve.MaxAge, defaultEventMaxAge),
+		}
+
+		for _, severity := range r.Severities {
+			if _, ok := pb.Severity_value[strings.ToUpper(severity)]; !ok {
+				return nil, fmt.Errorf("rule %d: unknown severity %q", n, severity)
+			}
+			er.severities[strings.ToLower(severity)] = true
+		}
+
+		for param, expr := range r.Params {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid regex for param %s: %w", n, param, err)
+			}
+			er.params[param] = re
+		}
+
+		for name := range r.Labels {
+			if !labelNameRegexp.MatchString(name) || reservedLabels[name] {
+				return nil, fmt.Errorf("rule %d: invalid label %q", n, name)
+			}
+		}
+
+		for name := range r.Annotations {
+			if !labelNameRegexp.MatchString(name) {
+				return nil, fmt.Errorf("rule %d: invalid annotation %q", n, name)
+			}
+		}
+
+		compiled = append(compiled, er)
+	}
+
+	return compiled, nil
+}
+
+func eventParams(event *pb.Event) map[string]string {
+	params := make(map[string]string, len(event.GetParameter()))
+	for _, p := range event.GetParameter() {
+		params[p.GetName()] = p.GetValue()
+	}
+
+	return params
+}
+
+func eventSeverity(event *pb.Event) string {
+	return strings.ToLower(event.GetSeverity().String())
+}
+
+// matches returns true if the event matches the UEI, severities and params
+// of the rule
+func (r eventRule) matches(event *pb.Event, params map[string]string) bool {
+	if !r.uei.MatchString(event.GetUei()) {
+		return false
+	}
+
+	if len(r.severities) > 0 && !r.severities[eventSeverity(event)] {
+		return false
+	}
+
+	for name, re := range r.params {
+		v, ok := params[name]
+		if !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// key correlates an alert from the rule with its clear event
+func (r eventRule) key(instanceID string, event *pb.Event, params map[string]string) string {
+	return instanceID + "/" + strconv.FormatUint(event.GetNodeId(), 10) + "/" + strconv.Itoa(r.index) + "/" + params[r.correlate]
+}
+
+// alert builds an alert for an event matching the rule
+func (r eventRule) alert(instanceID, instanceName string, event *pb.Event, params map[string]string, now time.Time) Alert {
+	name := r.name
+	if name == "" {
+		name = event.GetUei()
+	}
+
+	labels := map[string]string{
+		"alertname": name,
+		"severity":  eventSeverity(event),
+		"uei":       event.GetUei(),
+	}
+	if id := event.GetNodeId(); id != 0 {
+		labels["node_id"] = strconv.FormatUint(id, 10)
+	}
+	if label := event.GetLabel(); label != "" {
+		labels["node_name"] = label
+	}
+	if ip := event.GetIpAddress(); ip != "" {
+		labels["ip_address"] = ip
+	}
+	for label, param := range r.labels {
+		if v := params[param]; v != "" {
+			labels[label] = v
+		}
+	}
+	labels["instance_id"] = instanceID
+	labels["instance_name"] = instanceName
+
+	var annotations map[string]string
+	if len(r.annotation) > 0 {
+		annotations = make(map[string]string)
+		for annotation, param := range r.annotation {
+			if v := params[param]; v != "" {
+				annotations[annotation] = v
+			}
+		}
+	}
+
+	startsAt := now
+	if t := event.GetTime(); t != 0 {
+		startsAt = time.UnixMilli(int64(t))
+	}
+
+	return Alert{
+		Labels:      labels,
+		Annotations: annotations,
+		StartsAt:    startsAt,
+	}
+}
+
+type eventAlert struct {
+	alert   Alert
+	expires time.Time
+	refresh bool
+}
+
+// eventAlerts holds the alerts from events that are waiting for a clear event
+type eventAlerts struct {
+	mu     sync.Mutex
+	alerts map[string]eventAlert
+}
+
+func newEventAlerts() *eventAlerts {
+	return &eventAlerts{
+		alerts: make(map[string]eventAlert),
+	}
+}
+
+// set adds an alert that is kept until it expires, and is refreshed until
+// then when refresh is true
+func (e *eventAlerts) set(key string, alert Alert, expires time.Time, refresh bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.alerts[key] = eventAlert{alert: alert, expires: expires, refresh: refresh}
+}
+
+// clear removes the alert and returns it if it was found
+func (e *eventAlerts) clear(key string) (Alert, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ea, ok := e.alerts[key]
+	if ok {
+		delete(e.alerts, key)
+	}
+
+	return ea.alert, ok
+}
+
+// refresh removes expired alerts and extends the end time of any alerts
+// that only resolve via a clear event, up to when they expire, which are
+// returned to be re-sent
+func (e *eventAlerts) refresh(now time.Time, timeout time.Duration) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	list := make([]Alert, 0)
+	for k, v := range e.alerts {
+		if now.After(v.expires) {
+			delete(e.alerts, k)
+			continue
+		}
+		if !v.refresh {
+			continue
+		}
+
+		v.alert.EndsAt = minTime(now.Add(timeout), v.expires)
+		e.alerts[k] = v
+		list = append(list, v.alert)
+	}
+
+	return list
+}
+
+// handleEvents turns events that match a rule into alerts and resolves any
+// alerts for matching clear events
+func (s *ServiceSyncServer) handleEvents(instanceID, instanceName string, events []*pb.Event) {
+	if len(s.eventRules) == 0 || len(s.sinks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	list := make([]Alert, 0)
+	for _, event := range events {
+		params := eventParams(event)
+
+		for _, r := range s.eventRules {
+			// resolve on clear event
+			if r.clearUEI != "" && r.clearUEI == event.GetUei() {
+				if alert, ok := s.eventAlerts.clear(r.key(instanceID, event, params)); ok {
+					alert.EndsAt = now
+					list = append(list, alert)
+				}
+				continue
+			}
+
+			if !r.matches(event, params) {
+				continue
+			}
+
+			alert := r.alert(instanceID, instanceName, event, params, now)
+			if !s.relabel(alert.Labels) {
+				continue
+			}
+
+			// alerts without a timeout are refreshed until the max age
+			refresh := r.timeout <= 0
+			expires := now.Add(r.timeout)
+			alert.EndsAt = expires
+			if refresh {
+				expires = now.Add(r.maxAge)
+				alert.EndsAt = minTime(now.Add(s.resolveTimeout), expires)
+			}
+
+			if r.clearUEI != "" {
+				s.eventAlerts.set(r.key(instanceID, event, params), alert, expires, refresh)
+			}
+
+			list = append(list, alert)
+		}
+	}
+
+	s.send(list, nil)
+}
+
+// EventMetricsConfig limits the UEIs used as metric labels. When neither
+// Allow or Prefixes are set the full UEI is used.
+type EventMetricsConfig struct {
+	// Allow lists UEIs that are used as is
+	Allow []string `yaml:"allow"`
+
+	// Prefixes collapses any UEI starting with a prefix to that prefix
+	Prefixes []string `yaml:"prefixes"`
+}
+
+// ueiNormalizer maps UEIs to a bounded set of metric label values
+type ueiNormalizer struct {
+	allow    map[string]bool
+	prefixes []string
+}
+
+func newUEINormalizer(c EventMetricsConfig) *ueiNormalizer {
+	n := &ueiNormalizer{
+		allow:    make(map[string]bool, len(c.Allow)),
+		prefixes: slices.Clone(c.Prefixes),
+	}
+	for _, uei := range c.Allow {
+		n.allow[uei] = true
+	}
+
+	// longest prefix first so the most specific prefix is used
+	slices.SortFunc(n.prefixes, func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+
+	return n
+}
+
+func (n *ueiNormalizer) normalize(uei string) string {
+	if len(n.allow) == 0 && len(n.prefixes) == 0 {
+		return uei
+	}
+
+	if n.allow[uei] {
+		return uei
+	}
+
+	for _, prefix := range n.prefixes {
+		if strings.HasPrefix(uei, prefix) {
+			return prefix
+		}
+	}
+
+	return "other"
+}
+
+// observeEvents updates the event metrics for the events from an instance
+func (s *ServiceSyncServer) observeEvents(instanceID string, events []*pb.Event, now time.Time) {
+	for _, event := range events {
+		s.eventTotal.WithLabelValues(instanceID, s.ueiNormalizer.normalize(event.GetUei()), eventSeverity(event)).Inc()
+
+		if t := event.GetCreateTime(); t != 0 {
+			s.eventDelay.WithLabelValues(instanceID).Observe(max(now.Sub(time.UnixMilli(int64(t))).Seconds(), 0))
+		}
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.yaml.in/yaml/v3"
+)
+
+func testEvent(uei string, severity pb.Severity, nodeID uint64, params map[string]string) *pb.Event {
+	event := &pb.Event{}
+	event.SetUei(uei)
+	event.SetSeverity(severity)
+	event.SetNodeId(nodeID)
+	event.SetLabel("router1")
+
+	list := make([]*pb.EventParameter, 0, len(params))
+	for k, v := range params {
+		p := &pb.EventParameter{}
+		p.SetName(k)
+		p.SetValue(v)
+		list = append(list, p)
+	}
+	event.SetParameter(list)
+
+	return event
+}
+
+func testEventServer(t *testing.T, rules string) (*ServiceSyncServer, *sinkQueue) {
+	t.Helper()
+
+	var configs []EventRule
+	if err := yaml.Unmarshal([]byte(rules), &configs); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	srv, err := NewServiceSyncServer(WithConfig(&Config{EventRules: configs}), WithSink(&testSink{name: "test"}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	return srv, srv.sinks[0]
+}
+
+func receive(t *testing.T, q *sinkQueue) []Alert {
+	t.Helper()
+
+	select {
+	case item := <-q.queue:
+		return item.alerts
+	default:
+		return nil
+	}
+}
+
+func TestEventRuleMatches(t *testing.T) {
+	rules, err := newEventRules([]EventRule{{
+		UEI:        "uei.opennms.org/generic/traps/.*",
+		Severities: []string{"major", "Critical"},
+		Params:     map[string]string{"ifIndex": "[0-9]+"},
+		Resolve:    EventResolve{Timeout: time.Minute},
+	}})
+	if err != nil {
+		t.Fatalf("newEventRules() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		event *pb.Event
+		want  bool
+	}{
+		{"match", testEvent("uei.opennms.org/generic/traps/SNMP_Link_Down", pb.Severity_MAJOR, 1, map[string]string{"ifIndex": "3"}), true},
+		{"severity", testEvent("uei.opennms.org/generic/traps/SNMP_Link_Down", pb.Severity_CRITICAL, 1, map[string]string{"ifIndex": "3"}), true},
+		{"wrong uei", testEvent("uei.opennms.org/nodes/nodeDown", pb.Severity_MAJOR, 1, map[string]string{"ifIndex": "3"}), false},
+		{"wrong severity", testEvent("uei.opennms.org/generic/traps/SNMP_Link_Down", pb.Severity_MINOR, 1, map[string]string{"ifIndex": "3"}), false},
+		{"param mismatch", testEvent("uei.opennms.org/generic/traps/SNMP_Link_Down", pb.Severity_MAJOR, 1, map[string]string{"ifIndex": "x"}), false},
+		{"param missing", testEvent("uei.opennms.org/generic/traps/SNMP_Link_Down", pb.Severity_MAJOR, 1, nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules[0].matches(tt.event, eventParams(tt.event)); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleEventsTimeout(t *testing.T) {
+	srv, q := testEventServer(t, `
+- name: ColdStart
+  uei: uei.opennms.org/generic/traps/SNMP_Cold_Start
+  labels:
+    agent: agentAddress
+  annotations:
+    description: sysDescr
+  resolve:
+    timeout: 10m
+`)
+
+	before := time.Now()
+	srv.handleEvents("uuid", "horizon", []*pb.Event{
+		testEvent("uei.opennms.org/generic/traps/SNMP_Cold_Start", pb.Severity_WARNING, 1, map[string]string{"agentAddress": "192.0.2.1", "sysDescr": "router"}),
+		testEvent("uei.opennms.org/nodes/nodeDown", pb.Severity_MAJOR, 1, nil),
+	})
+
+	alerts := receive(t, q)
+	if len(alerts) != 1 {
+		t.Fatalf("handleEvents() sent %d alerts, want 1", len(alerts))
+	}
+
+	alert := alerts[0]
+	for k, v := range map[string]string{"alertname": "ColdStart", "agent": "192.0.2.1", "severity": "warning", "node_id": "1", "instance_id": "uuid"} {
+		if alert.Labels[k] != v {
+			t.Errorf("label %s = %q, want %q", k, alert.Labels[k], v)
+		}
+	}
+	if alert.Annotations["description"] != "router" {
+		t.Errorf("annotation description = %q, want %q", alert.Annotations["description"], "router")
+	}
+	if alert.EndsAt.Before(before.Add(time.Minute*10)) || alert.EndsAt.After(time.Now().Add(time.Minute*10)) {
+		t.Errorf("EndsAt = %v, want now + 10m", alert.EndsAt)
+	}
+
+	// timeout only rules are not refreshed
+	if list := srv.eventAlerts.refresh(time.Now(), time.Minute); len(list) != 0 {
+		t.Errorf("refresh() = %v, want none", list)
+	}
+}
+
+func TestHandleEventsClear(t *testing.T) {
+	srv, q := testEventServer(t, `
+- uei: uei.opennms.org/generic/traps/SNMP_Link_Down
+  resolve:
+    clear_uei: uei.opennms.org/generic/traps/SNMP_Link_Up
+    correlate_param: ifIndex
+`)
+
+	down := "uei.opennms.org/generic/traps/SNMP_Link_Down"
+	up := "uei.opennms.org/generic/traps/SNMP_Link_Up"
+
+	srv.handleEvents("uuid", "horizon", []*pb.Event{
+		testEvent(down, pb.Severity_MINOR, 1, map[string]string{"ifIndex": "1"}),
+		testEvent(down, pb.Severity_MINOR, 1, map[string]string{"ifIndex": "2"}),
+	})
+	if alerts := receive(t, q); len(alerts) != 2 {
+		t.Fatalf("handleEvents() sent %d alerts, want 2", len(alerts))
+	}
+
+	// alerts waiting for a clear are refreshed
+	if list := srv.eventAlerts.refresh(time.Now(), time.Minute); len(list) != 2 {
+		t.Errorf("refresh() returned %d alerts, want 2", len(list))
+	}
+
+	// clears for a different node or param do not match
+	srv.handleEvents("uuid", "horizon", []*pb.Event{
+		testEvent(up, pb.Severity_NORMAL, 2, map[string]string{"ifIndex": "1"}),
+		testEvent(up, pb.Severity_NORMAL, 1, map[string]string{"ifIndex": "3"}),
+	})
+	if alerts := receive(t, q); len(alerts) != 0 {
+		t.Errorf("handleEvents() sent %d alerts for unmatched clears, want 0", len(alerts))
+	}
+
+	now := time.Now()
+	srv.handleEvents("uuid", "horizon", []*pb.Event{
+		testEvent(up, pb.Severity_NORMAL, 1, map[string]string{"ifIndex": "1"}),
+	})
+	alerts := receive(t, q)
+	if len(alerts) != 1 {
+		t.Fatalf("handleEvents() sent %d alerts for clear, want 1", len(alerts))
+	}
+	if alerts[0].EndsAt.Before(now) || alerts[0].EndsAt.After(time.Now()) {
+		t.Errorf("cleared EndsAt = %v, want now", alerts[0].EndsAt)
+	}
+
+	if list := srv.eventAlerts.refresh(time.Now(), time.Minute); len(list) != 1 {
+		t.Errorf("refresh() returned %d alerts after clear, want 1", len(list))
+	}
+}
+
+func TestHandleEventsMaxAge(t *testing.T) {
+	srv, q := testEventServer(t, `
+- uei: uei.opennms.org/generic/traps/SNMP_Link_Down
+  resolve:
+    clear_uei: uei.opennms.org/generic/traps/SNMP_Link_Up
+    max_age: 1h
+`)
+
+	srv.handleEvents("uuid", "horizon", []*pb.Event{
+		testEvent("uei.opennms.org/generic/traps/SNMP_Link_Down", pb.Severity_MINOR, 1, nil),
+	})
+	if alerts := receive(t, q); len(alerts) != 1 {
+		t.Fatalf("handleEvents() sent %d alerts, want 1", len(alerts))
+	}
+
+	// alerts are not refreshed past the max age
+	now := time.Now()
+	list := srv.eventAlerts.refresh(now.Add(time.Minute*58), time.Minute*5)
+	if len(list) != 1 || list[0].EndsAt.After(now.Add(time.Hour)) {
+		t.Errorf("refresh() = %v, want one alert ending by the max age", list)
+	}
+
+	// alerts that never clear are removed after the max age
+	if list := srv.eventAlerts.refresh(now.Add(time.Hour*2), time.Minute*5); len(list) != 0 {
+		t.Errorf("refresh() returned %d alerts after max age, want 0", len(list))
+	}
+	if got := len(srv.eventAlerts.alerts); got != 0 {
+		t.Errorf("event alerts = %d, want 0", got)
+	}
+}
+
+func TestNewEventRulesInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule EventRule
+	}{
+		{"no uei", EventRule{Resolve: EventResolve{Timeout: time.Minute}}},
+		{"invalid uei", EventRule{UEI: "(", Resolve: EventResolve{Timeout: time.Minute}}},
+		{"no resolve", EventRule{UEI: "uei"}},
+		{"unknown severity", EventRule{UEI: "uei", Severities: []string{"urgent"}, Resolve: EventResolve{Timeout: time.Minute}}},
+		{"invalid param regex", EventRule{UEI: "uei", Params: map[string]string{"x": "("}, Resolve: EventResolve{Timeout: time.Minute}}},
+		{"reserved label", EventRule{UEI: "uei", Labels: map[string]string{"instance_id": "x"}, Resolve: EventResolve{Timeout: time.Minute}}},
+		{"negative max age", EventRule{UEI: "uei", Resolve: EventResolve{ClearUEI: "clear", MaxAge: -time.Minute}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newEventRules([]EventRule{tt.rule}); err == nil {
+				t.Error("newEventRules() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestUEINormalizer(t *testing.T) {
+	tests := []struct {
+		name   string
+		config EventMetricsConfig
+		uei    string
+		want   string
+	}{
+		{"no config", EventMetricsConfig{}, "uei.opennms.org/nodes/nodeDown", "uei.opennms.org/nodes/nodeDown"},
+		{"allowed", EventMetricsConfig{Allow: []string{"uei.opennms.org/nodes/nodeDown"}}, "uei.opennms.org/nodes/nodeDown", "uei.opennms.org/nodes/nodeDown"},
+		{"not allowed", EventMetricsConfig{Allow: []string{"uei.opennms.org/nodes/nodeDown"}}, "uei.opennms.org/nodes/nodeUp", "other"},
+		{"prefix", EventMetricsConfig{Prefixes: []string{"uei.opennms.org/generic/traps/"}}, "uei.opennms.org/generic/traps/SNMP_Link_Down", "uei.opennms.org/generic/traps/"},
+		{"longest prefix", EventMetricsConfig{Prefixes: []string{"uei.opennms.org/", "uei.opennms.org/nodes/"}}, "uei.opennms.org/nodes/nodeDown", "uei.opennms.org/nodes/"},
+		{"allow before prefix", EventMetricsConfig{Allow: []string{"uei.opennms.org/nodes/nodeDown"}, Prefixes: []string{"uei.opennms.org/"}}, "uei.opennms.org/nodes/nodeDown", "uei.opennms.org/nodes/nodeDown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newUEINormalizer(tt.config).normalize(tt.uei); got != tt.want {
+				t.Errorf("normalize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveEvents(t *testing.T) {
+	srv, err := NewServiceSyncServer(WithConfig(&Config{EventMetrics: EventMetricsConfig{Prefixes: []string{"uei.opennms.org/generic/traps/"}}}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	now := time.Now()
+	link := testEvent("uei.opennms.org/generic/traps/SNMP_Link_Down", pb.Severity_MINOR, 1, nil)
+	link.SetCreateTime(uint64(now.Add(-time.Second * 2).UnixMilli()))
+	cold := testEvent("uei.opennms.org/generic/traps/SNMP_Cold_Start", pb.Severity_MINOR, 1, nil)
+	down := testEvent("uei.opennms.org/nodes/nodeDown", pb.Severity_MAJOR, 1, nil)
+
+	srv.observeEvents("uuid", []*pb.Event{link, cold, down}, now)
+
+	if got := testutil.ToFloat64(srv.eventTotal.WithLabelValues("uuid", "uei.opennms.org/generic/traps/", "minor")); got != 2 {
+		t.Errorf("event total for traps = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(srv.eventTotal.WithLabelValues("uuid", "other", "major")); got != 1 {
+		t.Errorf("event total for other = %v, want 1", got)
+	}
+
+	// only events with a create time are observed
+	if got := testutil.CollectAndCount(srv.eventDelay); got != 1 {
+		t.Errorf("event delay series = %d, want 1", got)
+	}
+}
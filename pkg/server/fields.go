@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// defaultLabelFields maps label names to the alarm fields used to build the
+// labels for each alert unless overridden in the configuration
+var defaultLabelFields = map[string]string{
+	"alertname":     "uei",
+	"alarm_id":      "id",
+	"node_id":       "node_criteria.id",
+	"node_name":     "node_criteria.node_label",
+	"severity":      "severity",
+	"service":       "service_name",
+	"ip_address":    "ip_address",
+	"site":          "node_criteria.location",
+	"reduction_key": "reduction_key",
+	"clear_key":     "clear_key",
+}
+
+// reservedLabels cannot be mapped, the instance labels are always set from
+// the instance and every alert needs an alertname
+var reservedLabels = map[string]bool{
+	"alertname":     true,
+	"instance_id":   true,
+	"instance_name": true,
+}
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var (
+	alarmDescriptor = (&pb.Alarm{}).ProtoReflect().Descriptor()
+	nodeDescriptor  = (&pb.Node{}).ProtoReflect().Descriptor()
+	severityField   = alarmDescriptor.Fields().ByName("severity")
+)
+
+type mappedField struct {
+	name string
+	path []protoreflect.FieldDescriptor
+}
+
+// fieldMapping maps fields of an alarm or node to label or annotation names
+type fieldMapping []mappedField
+
+// newFieldMapping builds a mapping from the defaults and overrides, which
+// map a name to a dotted path of alarm fields such as
+// "node_criteria.foreign_source". An override with an empty path removes the
+// default of the same name.
+func newFieldMapping(defaults, overrides map[string]string) (fieldMapping, error) {
+	merged := make(map[string]string, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+		merged[k] = v
+	}
+
+	return buildFieldMapping(alarmDescriptor, merged)
+}
+
+// newNodeFieldMapping builds a mapping of names to fields of an inventory
+// node such as "sys_name"
+func newNodeFieldMapping(fields map[string]string) (fieldMapping, error) {
+	return buildFieldMapping(nodeDescriptor, fields)
+}
+
+func buildFieldMapping(desc protoreflect.MessageDescriptor, fields map[string]string) (fieldMapping, error) {
+	m := make(fieldMapping, 0, len(fields))
+	for name, field := range fields {
+		if !labelNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+
+		path, err := fieldPath(desc, field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field for %s: %w", name, err)
+		}
+
+		m = append(m, mappedField{name: name, path: path})
+	}
+
+	return m, nil
+}
+
+// mustFieldMapping is like newFieldMapping but panics on error
+func mustFieldMapping(defaults, overrides map[string]string) fieldMapping {
+	m, err := newFieldMapping(defaults, overrides)
+	if err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// fieldPath resolves a dotted path of field names to a scalar field
+func fieldPath(desc protoreflect.MessageDescriptor, field string) ([]protoreflect.FieldDescriptor, error) {
+	parts := strings.Split(field, ".")
+	path := make([]protoreflect.FieldDescriptor, 0, len(parts))
+	for n, part := range parts {
+		fd := desc.Fields().ByName(protoreflect.Name(part))
+		if fd == nil {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		if fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("repeated field %q is not supported", field)
+		}
+
+		path = append(path, fd)
+
+		if fd.Kind() == protoreflect.MessageKind {
+			if n == len(parts)-1 {
+				return nil, fmt.Errorf("field %q is a message", field)
+			}
+			desc = fd.Message()
+			continue
+		}
+
+		if n != len(parts)-1 {
+			return nil, fmt.Errorf("field %q is not a message", strings.Join(parts[:n+1], "."))
+		}
+	}
+
+	return path, nil
+}
+
+// apply adds the mapped values from the message to dst, skipping empty strings
+func (m fieldMapping) apply(msg protoreflect.ProtoMessage, dst map[string]string) {
+	for _, f := range m {
+		if v := fieldValue(msg.ProtoReflect(), f.path); v != "" {
+			dst[f.name] = v
+		}
+	}
+}
+
+func fieldValue(msg protoreflect.Message, path []protoreflect.FieldDescriptor) string {
+	for _, fd := range path[:len(path)-1] {
+		msg = msg.Get(fd).Message()
+	}
+
+	fd := path[len(path)-1]
+	v := msg.Get(fd)
+
+	// severity is sent as the lower case name rather than a number
+	if fd == severityField {
+		return strings.ToLower(pb.Severity_name[int32(v.Uint())])
+	}
+
+	return v.String()
+}
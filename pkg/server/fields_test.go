@@ -0,0 +1,120 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func testAlarm() *pb.Alarm {
+	alarm := &pb.Alarm{}
+	alarm.SetId(42)
+	alarm.SetUei("uei.opennms.org/nodes/nodeDown")
+	alarm.SetSeverity(uint32(pb.Severity_MAJOR))
+	alarm.SetServiceName("ICMP")
+	alarm.SetReductionKey("uei.opennms.org/nodes/nodeDown::1")
+	alarm.SetIfIndex(3)
+	alarm.SetOperatorInstructions("call someone")
+
+	nc := &pb.NodeCriteria{}
+	nc.SetId(1)
+	nc.SetNodeLabel("node1")
+	nc.SetForeignSource("servers")
+	nc.SetLocation("Default")
+	alarm.SetNodeCriteria(nc)
+
+	return alarm
+}
+
+func TestFieldMapping(t *testing.T) {
+	tests := []struct {
+		name      string
+		defaults  map[string]string
+		overrides map[string]string
+		want      map[string]string
+		wantErr   bool
+	}{
+		{"defaults", defaultLabelFields, nil, map[string]string{
+			"alertname":     "uei.opennms.org/nodes/nodeDown",
+			"alarm_id":      "42",
+			"node_id":       "1",
+			"node_name":     "node1",
+			"severity":      "major",
+			"service":       "ICMP",
+			"site":          "Default",
+			"reduction_key": "uei.opennms.org/nodes/nodeDown::1",
+		}, false},
+		{"override and remove", defaultLabelFields, map[string]string{
+			"foreign_source": "node_criteria.foreign_source",
+			"if_index":       "if_index",
+			"site":           "",
+			"alarm_id":       "",
+			"node_id":        "",
+			"reduction_key":  "",
+		}, map[string]string{
+			"alertname":      "uei.opennms.org/nodes/nodeDown",
+			"node_name":      "node1",
+			"severity":       "major",
+			"service":        "ICMP",
+			"foreign_source": "servers",
+			"if_index":       "3",
+		}, false},
+		{"annotations", nil, map[string]string{"instructions": "operator_instructions"}, map[string]string{"instructions": "call someone"}, false},
+		{"unknown field", nil, map[string]string{"x": "missing"}, nil, true},
+		{"message field", nil, map[string]string{"x": "node_criteria"}, nil, true},
+		{"repeated field", nil, map[string]string{"x": "relatedAlarm"}, nil, true},
+		{"not a message", nil, map[string]string{"x": "uei.id"}, nil, true},
+		{"invalid name", nil, map[string]string{"bad-name": "uei"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newFieldMapping(tt.defaults, tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newFieldMapping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			got := make(map[string]string)
+			m.apply(testAlarm(), got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"labels", "labels:\n  foreign_id: node_criteria.foreign_id\n", false},
+		{"annotations", "annotations:\n  instructions: operator_instructions\n", false},
+		{"reserved label", "labels:\n  instance_id: uei\n", true},
+		{"remove alertname", "labels:\n  alertname: \"\"\n", true},
+		{"unknown field", "labels:\n  foo: bar\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ParseConfig([]byte(tt.cfg))
+			if err != nil {
+				t.Fatalf("ParseConfig() error = %v", err)
+			}
+
+			if err := WithConfig(cfg)(defaultServiceSyncServer()); (err != nil) != tt.wantErr {
+				t.Errorf("WithConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	if _, err := ParseConfig([]byte("unknown: true\n")); err == nil {
+		t.Error("ParseConfig() error = nil for unknown key, want error")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type heartbeatState struct {
+	name     string
+	lastSeen time.Time
+	down     bool
+}
+
+// instanceDown is an instance that has not sent a heartbeat within the
+// timeout, changed is true if the instance has just gone down
+type instanceDown struct {
+	heartbeatState
+	changed bool
+}
+
+// heartbeats tracks the last heartbeat seen from each instance
+type heartbeats struct {
+	mu        sync.Mutex
+	instances map[string]*heartbeatState
+}
+
+func newHeartbeats() *heartbeats {
+	return &heartbeats{
+		instances: make(map[string]*heartbeatState),
+	}
+}
+
+// seen records a heartbeat for the instance and returns true if the instance
+// was previously considered down
+func (h *heartbeats) seen(id, name string, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.instances[id]
+	if !ok {
+		state = &heartbeatState{}
+		h.instances[id] = state
+	}
+
+	wasDown := state.down
+	state.name = name
+	state.lastSeen = now
+	state.down = false
+
+	return wasDown
+}
+
+// expired marks any instance not seen since the timeout as down and returns
+// the instances that are currently down
+func (h *heartbeats) expired(now time.Time, timeout time.Duration) map[string]instanceDown {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	down := make(map[string]instanceDown)
+	for id, state := range h.instances {
+		changed := false
+		if !state.down && now.Sub(state.lastSeen) > timeout {
+			state.down = true
+			changed = true
+		}
+
+		if state.down {
+			down[id] = instanceDown{*state, changed}
+		}
+	}
+
+	return down
+}
+
+// list returns a copy of the state of every instance
+func (h *heartbeats) list() map[string]heartbeatState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	list := make(map[string]heartbeatState, len(h.instances))
+	for id, state := range h.instances {
+		list[id] = *state
+	}
+
+	return list
+}
+
+// instanceDownAlert returns an OpenNMSInstanceDown alert for the instance
+// that ends at the provided time
+func instanceDownAlert(id, name string, startsAt, endsAt time.Time) Alert {
+	return Alert{
+		Labels: map[string]string{
+			"alertname":     "OpenNMSInstanceDown",
+			"instance_id":   id,
+			"instance_name": name,
+			"severity":      "critical",
+		},
+		StartsAt: startsAt,
+		EndsAt:   endsAt,
+	}
+}
+
+// checkHeartbeats fires an OpenNMSInstanceDown alert for each instance that
+// has not sent a heartbeat within the heartbeat timeout
+func (s *ServiceSyncServer) checkHeartbeats() {
+	now := time.Now()
+
+	down := s.heartbeats.expired(now, s.heartbeatTimeout)
+	if len(down) == 0 || len(s.sinks) == 0 {
+		return
+	}
+
+	list := make([]Alert, 0, len(down))
+	for id, state := range down {
+		// only warn when the instance goes down to avoid logging every check
+		level := slog.LevelDebug
+		if state.changed {
+			level = slog.LevelWarn
+		}
+		s.logger.Log(context.Background(), level, "no heartbeat from instance",
+			"instance_id", id,
+			"instance_name", state.name,
+			"last_seen", state.lastSeen,
+		)
+
+		list = append(list, instanceDownAlert(id, state.name, state.lastSeen.Add(s.heartbeatTimeout), now.Add(s.resolveTimeout)))
+	}
+
+	s.send(s.relabelAlerts(list), nil)
+}
+
+// heartbeatCheckInterval returns how often to check for missing heartbeats,
+// which is half the shorter of the heartbeat and resolve timeouts so the
+// OpenNMSInstanceDown alert is re-sent before Alertmanager resolves it
+func (s *ServiceSyncServer) heartbeatCheckInterval() time.Duration {
+	return min(s.heartbeatTimeout, s.resolveTimeout) / 2
+}
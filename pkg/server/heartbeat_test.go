@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeats(t *testing.T) {
+	h := newHeartbeats()
+	now := time.Now()
+	timeout := time.Minute
+
+	if h.seen("instance1", "one", now) {
+		t.Error("seen() = true for new instance, want false")
+	}
+	h.seen("instance2", "two", now.Add(-time.Minute*2))
+
+	down := h.expired(now, timeout)
+	if len(down) != 1 {
+		t.Fatalf("expired() returned %d instances, want 1", len(down))
+	}
+	if state, ok := down["instance2"]; !ok || state.name != "two" || !state.changed {
+		t.Errorf("expired() = %v, want instance2 changed", down)
+	}
+
+	// instance stays down until a heartbeat is seen
+	down = h.expired(now, timeout)
+	if len(down) != 1 {
+		t.Errorf("expired() returned %d instances, want 1", len(down))
+	}
+	if down["instance2"].changed {
+		t.Error("expired() changed = true for instance already down, want false")
+	}
+
+	if !h.seen("instance2", "two", now) {
+		t.Error("seen() = false for down instance, want true")
+	}
+	if h.seen("instance2", "two", now) {
+		t.Error("seen() = true for instance that is up, want false")
+	}
+
+	if down := h.expired(now, timeout); len(down) != 0 {
+		t.Errorf("expired() = %v, want none", down)
+	}
+}
+
+func TestInstanceDownAlert(t *testing.T) {
+	now := time.Now()
+	alert := instanceDownAlert("id", "name", now, now)
+
+	if got := alert.Labels["alertname"]; got != "OpenNMSInstanceDown" {
+		t.Errorf("instanceDownAlert() alertname = %q, want OpenNMSInstanceDown", got)
+	}
+	if got := alert.Labels["instance_id"]; got != "id" {
+		t.Errorf("instanceDownAlert() instance_id = %q, want id", got)
+	}
+	if got := alert.Labels["instance_name"]; got != "name" {
+		t.Errorf("instanceDownAlert() instance_name = %q, want name", got)
+	}
+}
+
+func TestHeartbeatCheckInterval(t *testing.T) {
+	tests := []struct {
+		name             string
+		heartbeatTimeout time.Duration
+		resolveTimeout   time.Duration
+		want             time.Duration
+	}{
+		{"heartbeat timeout shorter", time.Minute * 4, time.Minute * 5, time.Minute * 2},
+		{"resolve timeout shorter", time.Minute * 10, time.Minute * 2, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithHeartbeatTimeout(tt.heartbeatTimeout), WithResolveTimeout(tt.resolveTimeout))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			if got := srv.heartbeatCheckInterval(); got != tt.want {
+				t.Errorf("heartbeatCheckInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HTTPClientConfig configures how requests are made to Alertmanager, in the
+// same way as the http_config of a Prometheus alertmanager_config
+type HTTPClientConfig struct {
+	// TLSConfig configures TLS connections to Alertmanager
+	TLSConfig TLSConfig
+
+	// BasicAuth sets the credentials used for basic authentication
+	BasicAuth *BasicAuth
+
+	// BearerTokenFile is read on each request for the bearer token sent in
+	// the Authorization header
+	BearerTokenFile string
+}
+
+// TLSConfig configures the CA, client certificate and verification of TLS
+// connections to Alertmanager
+type TLSConfig struct {
+	// CAFile is the CA certificate bundle used to verify Alertmanager
+	CAFile string
+
+	// CertFile and KeyFile are the client certificate and key, which are
+	// read on each new connection
+	CertFile string
+	KeyFile  string
+
+	// ServerName overrides the name used to verify the certificate of
+	// Alertmanager
+	ServerName string
+
+	// InsecureSkipVerify disables verification of the certificate of
+	// Alertmanager
+	InsecureSkipVerify bool
+}
+
+// BasicAuth holds the username and password file used for basic
+// authentication, with the password file read on each request
+type BasicAuth struct {
+	Username     string
+	PasswordFile string
+}
+
+func (c HTTPClientConfig) validate() error {
+	if c.BasicAuth != nil && c.BearerTokenFile != "" {
+		return fmt.Errorf("at most one of basic auth and bearer token file may be set")
+	}
+
+	if c.BasicAuth != nil && c.BasicAuth.Username == "" {
+		return fmt.Errorf("basic auth requires a username")
+	}
+
+	if (c.TLSConfig.CertFile == "") != (c.TLSConfig.KeyFile == "") {
+		return fmt.Errorf("client certificate and key must be set together")
+	}
+
+	return nil
+}
+
+// tlsConfig returns the TLS configuration for connections to Alertmanager
+func (c TLSConfig) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		// load once so a bad CA certificate is found at startup
+		if _, err := loadCAFile(c.CAFile); err != nil {
+			return nil, err
+		}
+
+		// the CA is read on each new connection, which replaces the built in
+		// verification, so a rotated CA is used without a restart
+		if !c.InsecureSkipVerify {
+			cfg.InsecureSkipVerify = true
+			cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+				return verifyConnection(cs, c.CAFile)
+			}
+		}
+	}
+
+	if c.CertFile != "" {
+		// load once so a bad certificate or key is found at startup
+		if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to load client certificate: %w", err)
+			}
+
+			return &cert, nil
+		}
+	}
+
+	return cfg, nil
+}
+
+// loadCAFile reads a PEM encoded CA certificate bundle
+func loadCAFile(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+
+	return pool, nil
+}
+
+// verifyConnection verifies the certificate chain presented by Alertmanager
+// against the CA file and the server name of the connection, in the same way
+// as the built in verification
+func verifyConnection(cs tls.ConnectionState, caFile string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificate presented by server")
+	}
+
+	roots, err := loadCAFile(caFile)
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return &tls.CertificateVerificationError{UnverifiedCertificates: cs.PeerCertificates, Err: err}
+	}
+
+	return nil
+}
+
+// transport returns a transport for requests to Alertmanager using the TLS
+// configuration and credentials
+func (c HTTPClientConfig) transport() (http.RoundTripper, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := c.TLSConfig.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = tlsConfig
+
+	if c.BasicAuth == nil && c.BearerTokenFile == "" {
+		return base, nil
+	}
+
+	return &authTransport{
+		Transport:       base,
+		BasicAuth:       c.BasicAuth,
+		BearerTokenFile: c.BearerTokenFile,
+	}, nil
+}
+
+// readSecretFile returns the trimmed contents of a credentials file
+func readSecretFile(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// authTransport adds basic authentication or a bearer token to each request,
+// reading the credentials from file each time so changes are picked up
+// without a restart
+type authTransport struct {
+	Transport       http.RoundTripper
+	BasicAuth       *BasicAuth
+	BearerTokenFile string
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	newReq := req.Clone(req.Context())
+	if newReq.Header == nil {
+		newReq.Header = make(http.Header)
+	}
+
+	switch {
+	case t.BearerTokenFile != "":
+		token, err := readSecretFile(t.BearerTokenFile)
+		if err != nil {
+			closeBody(req)
+			return nil, fmt.Errorf("unable to read bearer token: %w", err)
+		}
+		newReq.Header.Set("Authorization", "Bearer "+token)
+	case t.BasicAuth != nil:
+		var password string
+		if t.BasicAuth.PasswordFile != "" {
+			p, err := readSecretFile(t.BasicAuth.PasswordFile)
+			if err != nil {
+				closeBody(req)
+				return nil, fmt.Errorf("unable to read basic auth password: %w", err)
+			}
+			password = p
+		}
+		newReq.SetBasicAuth(t.BasicAuth.Username, password)
+	}
+
+	return t.Transport.RoundTrip(newReq)
+}
+
+// closeBody closes the body of a request that is not sent, as required of a
+// http.RoundTripper
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
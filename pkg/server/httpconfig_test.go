@@ -0,0 +1,259 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testWriteFile writes contents to name in dir and returns the path
+func testWriteFile(t *testing.T, dir, name string, contents []byte) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, contents, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	return file
+}
+
+// testClientCert writes a self-signed client certificate and key for cn
+func testClientCert(t *testing.T, dir, cn string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error = %v", err)
+	}
+
+	cert := testWriteFile(t, dir, "client.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyFile := testWriteFile(t, dir, "client.key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+
+	return cert, keyFile
+}
+
+func TestHTTPClientConfigTLS(t *testing.T) {
+	am := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	am.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	am.StartTLS()
+	defer am.Close()
+
+	dir := t.TempDir()
+	ca := testWriteFile(t, dir, "ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: am.Certificate().Raw}))
+	cert, key := testClientCert(t, dir, "receiver-a")
+
+	tests := []struct {
+		name    string
+		cfg     TLSConfig
+		wantCN  string
+		wantErr bool
+	}{
+		{"unknown ca", TLSConfig{}, "", true},
+		{"ca", TLSConfig{CAFile: ca}, "", false},
+		{"insecure skip verify", TLSConfig{InsecureSkipVerify: true}, "", false},
+		{"server name", TLSConfig{CAFile: ca, ServerName: "example.com"}, "", false},
+		{"wrong server name", TLSConfig{CAFile: ca, ServerName: "am.example.net"}, "", true},
+		{"client certificate", TLSConfig{CAFile: ca, CertFile: cert, KeyFile: key}, "receiver-a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithHTTPClientConfig(HTTPClientConfig{TLSConfig: tt.cfg}))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			resp, err := srv.httpClient.Get(am.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+
+			b := make([]byte, 64)
+			n, _ := resp.Body.Read(b)
+			if got := string(b[:n]); got != tt.wantCN {
+				t.Errorf("client certificate = %q, want %q", got, tt.wantCN)
+			}
+		})
+	}
+
+	// the client certificate is read again for new connections
+	srv, err := NewServiceSyncServer(WithHTTPClientConfig(HTTPClientConfig{TLSConfig: TLSConfig{CAFile: ca, CertFile: cert, KeyFile: key}}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	testClientCert(t, dir, "receiver-b")
+	srv.httpClient.CloseIdleConnections()
+
+	resp, err := srv.httpClient.Get(am.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	b := make([]byte, 64)
+	n, _ := resp.Body.Read(b)
+	if got := string(b[:n]); got != "receiver-b" {
+		t.Errorf("client certificate after rotation = %q, want %q", got, "receiver-b")
+	}
+}
+
+func TestHTTPClientConfigCARotation(t *testing.T) {
+	am := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer am.Close()
+
+	// start with a CA that did not sign the certificate of Alertmanager
+	dir := t.TempDir()
+	other, _ := testClientCert(t, dir, "other-ca")
+	b, err := os.ReadFile(other)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	ca := testWriteFile(t, dir, "ca.crt", b)
+
+	srv, err := NewServiceSyncServer(WithHTTPClientConfig(HTTPClientConfig{TLSConfig: TLSConfig{CAFile: ca}}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	if _, err := srv.httpClient.Get(am.URL); err == nil {
+		t.Fatal("Get() with the wrong CA error = nil, want error")
+	}
+
+	// the CA is read again for new connections
+	testWriteFile(t, dir, "ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: am.Certificate().Raw}))
+	srv.httpClient.CloseIdleConnections()
+
+	resp, err := srv.httpClient.Get(am.URL)
+	if err != nil {
+		t.Fatalf("Get() after CA rotation error = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestHTTPClientConfigAuth(t *testing.T) {
+	var gotAuth, gotHeader string
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotHeader = r.Header.Get("X-Scope-OrgID")
+	}))
+	defer am.Close()
+
+	dir := t.TempDir()
+	password := testWriteFile(t, dir, "password", []byte("first\n"))
+	token := testWriteFile(t, dir, "token", []byte("token-1\n"))
+	headers := WithHeaders(map[string]string{"X-Scope-OrgID": "tenant"})
+
+	tests := []struct {
+		name     string
+		opts     func(HTTPClientConfig) []ServiceSyncServerOption
+		cfg      HTTPClientConfig
+		file     string
+		contents string
+		want     []string
+	}{
+		{
+			name: "basic auth",
+			opts: func(cfg HTTPClientConfig) []ServiceSyncServerOption {
+				return []ServiceSyncServerOption{WithHTTPClientConfig(cfg), headers}
+			},
+			cfg:      HTTPClientConfig{BasicAuth: &BasicAuth{Username: "receiver", PasswordFile: password}},
+			file:     password,
+			contents: "second",
+			want:     []string{"Basic cmVjZWl2ZXI6Zmlyc3Q=", "Basic cmVjZWl2ZXI6c2Vjb25k"},
+		},
+		{
+			name: "bearer token",
+			opts: func(cfg HTTPClientConfig) []ServiceSyncServerOption {
+				return []ServiceSyncServerOption{headers, WithHTTPClientConfig(cfg)}
+			},
+			cfg:      HTTPClientConfig{BearerTokenFile: token},
+			file:     token,
+			contents: "token-2",
+			want:     []string{"Bearer token-1", "Bearer token-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(tt.opts(tt.cfg)...)
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			for n, want := range tt.want {
+				// credentials are read again for each request
+				if n > 0 {
+					testWriteFile(t, filepath.Dir(tt.file), filepath.Base(tt.file), []byte(tt.contents))
+				}
+
+				resp, err := srv.httpClient.Get(am.URL)
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				resp.Body.Close()
+
+				if gotAuth != want {
+					t.Errorf("Authorization = %q, want %q", gotAuth, want)
+				}
+				if gotHeader != "tenant" {
+					t.Errorf("X-Scope-OrgID = %q, want %q", gotHeader, "tenant")
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPClientConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     HTTPClientConfig
+		wantErr bool
+	}{
+		{"empty", HTTPClientConfig{}, false},
+		{"basic auth and bearer token", HTTPClientConfig{BasicAuth: &BasicAuth{Username: "a"}, BearerTokenFile: "token"}, true},
+		{"basic auth without username", HTTPClientConfig{BasicAuth: &BasicAuth{PasswordFile: "password"}}, true},
+		{"cert without key", HTTPClientConfig{TLSConfig: TLSConfig{CertFile: "client.crt"}}, true},
+		{"missing ca", HTTPClientConfig{TLSConfig: TLSConfig{CAFile: filepath.Join(t.TempDir(), "ca.crt")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServiceSyncServer(WithHTTPClientConfig(tt.cfg)); (err != nil) != tt.wantErr {
+				t.Errorf("NewServiceSyncServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/andrewheberle/onms-grpc-receiver/pkg/bsm"
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ClientIdentities maps the subject common name or a SAN (DNS name, email
+// address or URI) of a client certificate to the instance IDs that the
+// client is allowed to send messages for. Business service updates carry a
+// foreign source rather than an instance ID, so for those the foreign sources
+// are mapped instead.
+type ClientIdentities map[string][]string
+
+// ParseClientIdentities converts a mapping of identities to instance IDs,
+// separated by "|", into ClientIdentities
+func ParseClientIdentities(m map[string]string) (ClientIdentities, error) {
+	identities := make(ClientIdentities, len(m))
+	for identity, v := range m {
+		if identity == "" {
+			return nil, fmt.Errorf("empty client identity")
+		}
+
+		for id := range strings.SplitSeq(v, "|") {
+			if id = strings.TrimSpace(id); id == "" {
+				return nil, fmt.Errorf("empty instance id for client identity %s", identity)
+			}
+			identities[identity] = append(identities[identity], id)
+		}
+	}
+
+	return identities, nil
+}
+
+// certIdentities returns the names a certificate may be matched by
+func certIdentities(cert *x509.Certificate) []string {
+	names := make([]string, 0, 1+len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs))
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+
+	return names
+}
+
+// allowed returns the instance IDs that the certificate may be used for
+func (c ClientIdentities) allowed(cert *x509.Certificate) map[string]bool {
+	allowed := make(map[string]bool)
+	for _, name := range certIdentities(cert) {
+		for _, id := range c[name] {
+			allowed[id] = true
+		}
+	}
+
+	return allowed
+}
+
+// claimedInstance returns the instance ID claimed by a message, or the
+// foreign source of business service updates, if any
+func claimedInstance(m any) (string, bool) {
+	switch v := m.(type) {
+	case interface{ GetInstanceId() string }:
+		return v.GetInstanceId(), true
+	case *pb.HeartBeat:
+		return v.GetMonitoringInstance().GetInstanceId(), true
+	case *bsm.HeartBeat:
+		return v.GetMonitoringInstance().GetInstanceId(), true
+	case *bsm.StateUpdateList:
+		return v.GetForeignSource(), true
+	case *bsm.InventoryUpdateList:
+		return v.GetForeignSource(), true
+	}
+
+	return "", false
+}
+
+// reasons for rejecting a stream, used as the reason label of the
+// onmsgrpc_grpc_streams_rejected_total metric
+const (
+	rejectedMissingToken  = "missing_token"
+	rejectedInvalidToken  = "invalid_token"
+	rejectedNoCertificate = "no_certificate"
+	rejectedNotAllowed    = "certificate_not_allowed"
+	rejectedWrongInstance = "instance_not_allowed"
+)
+
+// identityStream checks the instance claimed by each received message
+// against those allowed for the client
+type identityStream struct {
+	grpc.ServerStream
+	allowed  map[string]bool
+	logger   *slog.Logger
+	rejected *prometheus.CounterVec
+}
+
+func (s *identityStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	if id, ok := claimedInstance(m); ok && !s.allowed[id] {
+		s.logger.Warn("rejected message for instance not allowed for client", "instance_id", id)
+		s.rejected.WithLabelValues(rejectedWrongInstance).Inc()
+		return status.Errorf(codes.PermissionDenied, "client is not allowed to send messages for instance %q", id)
+	}
+
+	return nil
+}
+
+// exemptMethod reports whether the method is part of the health or
+// reflection services, which do not carry instance IDs and so are not subject
+// to client authentication
+func exemptMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/") || strings.HasPrefix(fullMethod, "/grpc.reflection.")
+}
+
+// IdentityStreamInterceptor returns a stream interceptor that rejects any
+// message claiming an instance ID that is not allowed for the verified
+// client certificate of the peer. The health and reflection services are
+// exempt.
+func (s *ServiceSyncServer) IdentityStreamInterceptor(identities ClientIdentities) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if exemptMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		p, ok := peer.FromContext(ss.Context())
+		if !ok {
+			s.streamsRejected.WithLabelValues(rejectedNoCertificate).Inc()
+			return status.Error(codes.Unauthenticated, "no peer information")
+		}
+
+		tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+		if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+			s.streamsRejected.WithLabelValues(rejectedNoCertificate).Inc()
+			return status.Error(codes.Unauthenticated, "no verified client certificate")
+		}
+
+		cert := tlsInfo.State.VerifiedChains[0][0]
+		allowed := identities.allowed(cert)
+		if len(allowed) == 0 {
+			s.logger.Warn("rejected client certificate without an allowed instance", "subject", cert.Subject.String(), "method", info.FullMethod)
+			s.streamsRejected.WithLabelValues(rejectedNotAllowed).Inc()
+			return status.Error(codes.PermissionDenied, "client certificate is not allowed to send messages for any instance")
+		}
+
+		return handler(srv, &identityStream{
+			ServerStream: ss,
+			allowed:      allowed,
+			logger:       s.logger.With("subject", cert.Subject.String(), "method", info.FullMethod),
+			rejected:     s.streamsRejected,
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"net/url"
+	"testing"
+
+	"github.com/andrewheberle/onms-grpc-receiver/pkg/bsm"
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// testServerStream returns each message in turn from RecvMsg
+type testServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages []proto.Message
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) RecvMsg(m any) error {
+	next := s.messages[0]
+	s.messages = s.messages[1:]
+	proto.Merge(m.(proto.Message), next)
+
+	return nil
+}
+
+func testPeerContext(cert *x509.Certificate) context.Context {
+	p := &peer.Peer{}
+	if cert != nil {
+		p.AuthInfo = credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}}
+	}
+
+	return peer.NewContext(context.Background(), p)
+}
+
+func TestParseClientIdentities(t *testing.T) {
+	got, err := ParseClientIdentities(map[string]string{"horizon-a": "uuid-1|uuid-2", "horizon-b": "uuid-3"})
+	if err != nil {
+		t.Fatalf("ParseClientIdentities() error = %v", err)
+	}
+	if len(got["horizon-a"]) != 2 || got["horizon-b"][0] != "uuid-3" {
+		t.Errorf("ParseClientIdentities() = %v, want two instances for horizon-a", got)
+	}
+
+	if _, err := ParseClientIdentities(map[string]string{"horizon-a": "uuid-1|"}); err == nil {
+		t.Error("ParseClientIdentities() error = nil for empty instance, want error")
+	}
+}
+
+func TestIdentityStreamInterceptor(t *testing.T) {
+	identities := ClientIdentities{
+		"horizon-a":                     {"uuid-1"},
+		"horizon-b.example.com":         {"uuid-2"},
+		"spiffe://example.com/horizonc": {"uuid-3"},
+	}
+
+	byCN := &x509.Certificate{Subject: pkix.Name{CommonName: "horizon-a"}}
+	byDNS := &x509.Certificate{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"horizon-b.example.com"}}
+	byURI := &x509.Certificate{URIs: []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/horizonc"}}}
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}
+
+	alarms := func(id string) proto.Message {
+		m := &pb.AlarmUpdateList{}
+		m.SetInstanceId(id)
+		return m
+	}
+	heartbeat := func(id string) proto.Message {
+		mi := &pb.MonitoringInstance{}
+		mi.SetInstanceId(id)
+		m := &pb.HeartBeat{}
+		m.SetMonitoringInstance(mi)
+		return m
+	}
+	states := func(foreignSource string) proto.Message {
+		m := &bsm.StateUpdateList{}
+		m.SetForeignSource(foreignSource)
+		return m
+	}
+
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		message    proto.Message
+		wantCode   codes.Code
+		wantReason string
+	}{
+		{"common name", byCN, alarms("uuid-1"), codes.OK, ""},
+		{"dns san", byDNS, alarms("uuid-2"), codes.OK, ""},
+		{"uri san", byURI, heartbeat("uuid-3"), codes.OK, ""},
+		{"foreign source", byCN, states("uuid-1"), codes.OK, ""},
+		{"wrong instance", byCN, alarms("uuid-2"), codes.PermissionDenied, rejectedWrongInstance},
+		{"wrong heartbeat instance", byDNS, heartbeat("uuid-1"), codes.PermissionDenied, rejectedWrongInstance},
+		{"wrong foreign source", byDNS, states("uuid-1"), codes.PermissionDenied, rejectedWrongInstance},
+		{"unknown certificate", unknown, alarms("uuid-1"), codes.PermissionDenied, rejectedNotAllowed},
+		{"no certificate", nil, alarms("uuid-1"), codes.Unauthenticated, rejectedNoCertificate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithLogger(slog.New(slog.DiscardHandler)))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+			interceptor := srv.IdentityStreamInterceptor(identities)
+			stream := &testServerStream{ctx: testPeerContext(tt.cert), messages: []proto.Message{tt.message}}
+
+			err = interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test"}, func(srv any, ss grpc.ServerStream) error {
+				return ss.RecvMsg(tt.message.ProtoReflect().New().Interface())
+			})
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("interceptor() code = %v, want %v (%v)", got, tt.wantCode, err)
+			}
+			if got := testutil.CollectAndCount(srv.streamsRejected); tt.wantReason == "" && got != 0 {
+				t.Errorf("streams rejected = %d, want 0", got)
+			}
+			if tt.wantReason != "" {
+				if got := testutil.ToFloat64(srv.streamsRejected.WithLabelValues(tt.wantReason)); got != 1 {
+					t.Errorf("streams rejected with reason %s = %v, want 1", tt.wantReason, got)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityStreamInterceptorExempt(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		wantCode codes.Code
+	}{
+		{"health check", "/grpc.health.v1.Health/Check", codes.OK},
+		{"health watch", "/grpc.health.v1.Health/Watch", codes.OK},
+		{"reflection", "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", codes.OK},
+		{"reflection alpha", "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", codes.OK},
+		{"receiver", pb.NmsInventoryServiceSync_AlarmUpdate_FullMethodName, codes.Unauthenticated},
+		{"health prefix", "/grpc.health.v1.HealthCheck/Check", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithLogger(slog.New(slog.DiscardHandler)))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+			interceptor := srv.IdentityStreamInterceptor(ClientIdentities{})
+			stream := &testServerStream{ctx: testPeerContext(nil)}
+
+			err = interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: tt.method}, func(srv any, ss grpc.ServerStream) error {
+				return nil
+			})
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("interceptor() code = %v, want %v (%v)", got, tt.wantCode, err)
+			}
+		})
+	}
+}
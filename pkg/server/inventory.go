@@ -0,0 +1,111 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+// inventory holds the nodes received from each instance
+type inventory struct {
+	mu        sync.RWMutex
+	instances map[string]map[uint64]*pb.Node
+	names     map[string]string
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		instances: make(map[string]map[uint64]*pb.Node),
+		names:     make(map[string]string),
+	}
+}
+
+// update adds or replaces the nodes for the instance. A snapshot replaces
+// all nodes previously seen from the instance. The number of nodes now held
+// for the instance is returned.
+func (i *inventory) update(instanceID, instanceName string, snapshot bool, nodes []*pb.Node) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.names[instanceID] = instanceName
+
+	current, ok := i.instances[instanceID]
+	if !ok || snapshot {
+		current = make(map[uint64]*pb.Node, len(nodes))
+		i.instances[instanceID] = current
+	}
+
+	for _, node := range nodes {
+		current[node.GetId()] = node
+	}
+
+	return len(current)
+}
+
+// node returns the node from the instance or nil if it is not known
+func (i *inventory) node(instanceID string, id uint64) *pb.Node {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.instances[instanceID][id]
+}
+
+// status returns the name and number of nodes for every instance
+func (i *inventory) status() map[string]inventoryStatus {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	list := make(map[string]inventoryStatus, len(i.instances))
+	for id, nodes := range i.instances {
+		list[id] = inventoryStatus{name: i.names[id], nodes: len(nodes)}
+	}
+
+	return list
+}
+
+// inventoryStatus is the name and number of nodes held for an instance
+type inventoryStatus struct {
+	name  string
+	nodes int
+}
+
+// snmpInterface returns the SNMP interface of the node with the provided
+// ifIndex or nil if it is not known
+func snmpInterface(node *pb.Node, ifIndex uint32) *pb.SnmpInterface {
+	if node == nil || ifIndex == 0 {
+		return nil
+	}
+
+	for _, iface := range node.GetSnmpInterface() {
+		if iface.GetIfIndex() == ifIndex {
+			return iface
+		}
+	}
+
+	return nil
+}
+
+// interfaceLabels adds the name, alias and speed of the interface as labels.
+// The interface description is used when the name is not set.
+func interfaceLabels(iface *pb.SnmpInterface, labels map[string]string) {
+	if iface == nil {
+		return
+	}
+
+	name := iface.GetIfName()
+	if name == "" {
+		name = iface.GetIfDescr()
+	}
+	if name != "" {
+		labels["interface"] = name
+	}
+
+	if alias := iface.GetIfAlias(); alias != "" {
+		labels["interface_alias"] = alias
+	}
+
+	if speed := iface.GetIfSpeed(); speed != 0 {
+		labels["interface_speed"] = strconv.FormatUint(speed, 10)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func testNode(id uint64, sysName string) *pb.Node {
+	node := &pb.Node{}
+	node.SetId(id)
+	node.SetSysName(sysName)
+	node.SetSysContact("noc@example.com")
+
+	return node
+}
+
+func TestInventory(t *testing.T) {
+	inv := newInventory()
+
+	if got := inv.update("instance1", "horizon", true, []*pb.Node{testNode(1, "one"), testNode(2, "two")}); got != 2 {
+		t.Errorf("update() snapshot = %d, want 2", got)
+	}
+
+	// incremental updates add or replace nodes
+	if got := inv.update("instance1", "horizon", false, []*pb.Node{testNode(2, "two-renamed"), testNode(3, "three")}); got != 3 {
+		t.Errorf("update() incremental = %d, want 3", got)
+	}
+	if got := inv.node("instance1", 2).GetSysName(); got != "two-renamed" {
+		t.Errorf("node() sys_name = %q, want %q", got, "two-renamed")
+	}
+
+	// other instances are separate
+	if got := inv.node("instance2", 1); got != nil {
+		t.Errorf("node() for other instance = %v, want nil", got)
+	}
+
+	// a snapshot replaces all nodes
+	if got := inv.update("instance1", "horizon", true, []*pb.Node{testNode(3, "three")}); got != 1 {
+		t.Errorf("update() snapshot = %d, want 1", got)
+	}
+	if got := inv.node("instance1", 1); got != nil {
+		t.Errorf("node() after snapshot = %v, want nil", got)
+	}
+}
+
+func TestHandleAlarmsNodeLabels(t *testing.T) {
+	cfg, err := ParseConfig([]byte("node_labels:\n  sys_name: sys_name\n  sys_contact: sys_contact\n"))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+
+	srv, err := NewServiceSyncServer(WithConfig(cfg), WithSink(&testSink{name: "test"}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	srv.inventory.update("instance1", "horizon", true, []*pb.Node{testNode(1, "router1")})
+
+	alarm := testAlarm()
+	alarm.SetLastEventTime(uint64(time.Now().UnixMilli()))
+	srv.handleAlarms([]instanceAlarm{{alarm: alarm, instanceID: "instance1", now: time.Now()}}, nil)
+
+	item := <-srv.sinks[0].queue
+	if len(item.alerts) != 1 {
+		t.Fatalf("handleAlarms() sent %d alerts, want 1", len(item.alerts))
+	}
+
+	labels := item.alerts[0].Labels
+	if labels["sys_name"] != "router1" || labels["sys_contact"] != "noc@example.com" {
+		t.Errorf("handleAlarms() labels = %v, want sys_name and sys_contact", labels)
+	}
+}
+
+func TestInterfaceLabels(t *testing.T) {
+	named := &pb.SnmpInterface{}
+	named.SetIfIndex(3)
+	named.SetIfName("Gi0/1")
+	named.SetIfDescr("GigabitEthernet0/1")
+	named.SetIfAlias("uplink to core")
+	named.SetIfSpeed(1000000000)
+
+	described := &pb.SnmpInterface{}
+	described.SetIfIndex(4)
+	described.SetIfDescr("eth0")
+
+	node := testNode(1, "router1")
+	node.SetSnmpInterface([]*pb.SnmpInterface{named, described})
+
+	tests := []struct {
+		name    string
+		node    *pb.Node
+		ifIndex uint32
+		want    map[string]string
+	}{
+		{"named", node, 3, map[string]string{"interface": "Gi0/1", "interface_alias": "uplink to core", "interface_speed": "1000000000"}},
+		{"description only", node, 4, map[string]string{"interface": "eth0"}},
+		{"unknown index", node, 5, map[string]string{}},
+		{"no index", node, 0, map[string]string{}},
+		{"no node", nil, 3, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			interfaceLabels(snmpInterface(tt.node, tt.ifIndex), got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("interfaceLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
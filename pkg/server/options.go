@@ -25,7 +25,28 @@ func WithLogger(logger *slog.Logger) ServiceSyncServerOption {
 func WithHeaders(headers map[string]string) ServiceSyncServerOption {
 	return func(s *ServiceSyncServer) error {
 		s.httpClient.Transport = &customTransport{
-			Headers: headers,
+			Transport: s.httpClient.Transport,
+			Headers:   headers,
+		}
+
+		return nil
+	}
+}
+
+// WithHTTPClientConfig sets the TLS configuration and credentials used for
+// requests to Alertmanager
+func WithHTTPClientConfig(cfg HTTPClientConfig) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		transport, err := cfg.transport()
+		if err != nil {
+			return fmt.Errorf("invalid alertmanager http config: %w", err)
+		}
+
+		// keep any custom headers
+		if t, ok := s.httpClient.Transport.(*customTransport); ok {
+			t.Transport = transport
+		} else {
+			s.httpClient.Transport = transport
 		}
 
 		return nil
@@ -67,6 +88,8 @@ func WithSRVCacheTTL(d time.Duration) ServiceSyncServerOption {
 func WithAlertManagerSrv(scheme, srv string) ServiceSyncServerOption {
 	return func(s *ServiceSyncServer) error {
 		cache := &srvCache{}
+		s.srvCache = cache
+		s.srvName = srv
 
 		resolve := func() ([]string, error) {
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
@@ -128,6 +151,131 @@ func WithURLMapping(m map[string]string) ServiceSyncServerOption {
 	}
 }
 
+// WithConfig applies the alert configuration
+func WithConfig(cfg *Config) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		for _, m := range []map[string]string{cfg.Labels, cfg.NodeLabels} {
+			for name := range m {
+				if reservedLabels[name] {
+					return fmt.Errorf("label %s cannot be mapped", name)
+				}
+			}
+		}
+
+		labels, err := newFieldMapping(defaultLabelFields, cfg.Labels)
+		if err != nil {
+			return fmt.Errorf("labels: %w", err)
+		}
+
+		nodeLabels, err := newNodeFieldMapping(cfg.NodeLabels)
+		if err != nil {
+			return fmt.Errorf("node_labels: %w", err)
+		}
+
+		categories, err := newCategoryLabels(cfg.Categories)
+		if err != nil {
+			return fmt.Errorf("categories: %w", err)
+		}
+
+		annotations, err := newFieldMapping(nil, cfg.Annotations)
+		if err != nil {
+			return fmt.Errorf("annotations: %w", err)
+		}
+
+		templates, err := newAnnotationTemplates(cfg.Templates)
+		if err != nil {
+			return fmt.Errorf("templates: %w", err)
+		}
+
+		rules, err := newRelabelRules(cfg.RelabelConfigs)
+		if err != nil {
+			return fmt.Errorf("alert_relabel_configs: %w", err)
+		}
+
+		eventRules, err := newEventRules(cfg.EventRules)
+		if err != nil {
+			return fmt.Errorf("event_rules: %w", err)
+		}
+
+		situations, err := newSituations(cfg.Situations)
+		if err != nil {
+			return fmt.Errorf("situations: %w", err)
+		}
+
+		if err := cfg.ProblemWithoutClear.validate(); err != nil {
+			return fmt.Errorf("problem_without_clear: %w", err)
+		}
+
+		s.labelFields = labels
+		s.nodeLabelFields = nodeLabels
+		s.categoryLabels = categories
+		s.interfaceLabels = cfg.InterfaceLabels
+		s.annotationFields = annotations
+		s.annotationTemplates = templates
+		s.relabelRules = rules
+		s.eventRules = eventRules
+		s.ueiNormalizer = newUEINormalizer(cfg.EventMetrics)
+		s.problemWithoutClear = cfg.ProblemWithoutClear
+		s.situations = situations
+
+		return nil
+	}
+}
+
+// WithAckSilences creates an Alertmanager silence lasting duration, which is
+// renewed as required, for each acknowledged alarm. The silences are saved
+// to file, if set, so they can be expired after a restart.
+func WithAckSilences(file string, duration time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if duration <= 0 {
+			return fmt.Errorf("invalid silence duration: %s", duration)
+		}
+		s.silenceFile = file
+		s.silenceDuration = duration
+
+		return nil
+	}
+}
+
+// WithProbeInterval sets how often the status of each Alertmanager is probed
+// for readiness, where 0 disables probing
+func WithProbeInterval(d time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if d < 0 {
+			return fmt.Errorf("invalid probe interval: %s", d)
+		}
+		s.probeInterval = d
+
+		return nil
+	}
+}
+
+// WithServiceDiscoveryPort sets the port added to service discovery targets
+func WithServiceDiscoveryPort(port int) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid port: %d", port)
+		}
+		s.sdPort = port
+
+		return nil
+	}
+}
+
+// WithFileSD enables writing service discovery targets to a Prometheus
+// file_sd file at the provided interval
+func WithFileSD(path string, interval time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if interval <= 0 {
+			return fmt.Errorf("file_sd interval must be greater than zero")
+		}
+		s.sdFile = path
+		s.sdFileInterval = interval
+
+		return nil
+	}
+}
+
 func WithRegistry(reg *prometheus.Registry) ServiceSyncServerOption {
 	return func(s *ServiceSyncServer) error {
 		s.registry = reg
@@ -152,6 +300,127 @@ func WithResolveTimeout(d time.Duration) ServiceSyncServerOption {
 	}
 }
 
+func WithRefreshInterval(d time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		s.refreshInterval = d
+
+		return nil
+	}
+}
+
+func WithHeartbeatTimeout(d time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		s.heartbeatTimeout = d
+
+		return nil
+	}
+}
+
+// WithQueueDir enables a durable queue stored in dir, so alarms that have not
+// been delivered to Alertmanager are replayed on startup
+func WithQueueDir(dir string) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		s.queueDir = dir
+
+		return nil
+	}
+}
+
+// WithQueueRetryInterval sets how often batches in the durable queue that
+// could not be delivered are retried, 0 disables retrying until the next
+// startup
+func WithQueueRetryInterval(d time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		s.queueRetryInterval = d
+
+		return nil
+	}
+}
+
+// WithQueueMaxAge sets how long batches in the durable queue that could not
+// be delivered are kept before they are dropped, 0 keeps them until they are
+// delivered
+func WithQueueMaxAge(d time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		s.queueMaxAge = d
+
+		return nil
+	}
+}
+
+func WithSendTimeout(d time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		s.sendTimeout = d
+
+		return nil
+	}
+}
+
+// WithRetries sets the number of times a failed request to an Alertmanager is
+// retried
+func WithRetries(n int) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if n < 0 {
+			return fmt.Errorf("retries must not be negative: %d", n)
+		}
+		s.retries = n
+
+		return nil
+	}
+}
+
+// WithRetryBackoff sets the initial and maximum delay between retries, which
+// doubles after each attempt with random jitter applied
+func WithRetryBackoff(base, limit time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if base > limit {
+			return fmt.Errorf("initial backoff (%s) is greater than the maximum (%s)", base, limit)
+		}
+		s.retryBackoff = base
+		s.retryMaxBackoff = limit
+
+		return nil
+	}
+}
+
+// WithCircuitBreaker sets the number of consecutive failures before requests
+// to an Alertmanager are stopped and the cooldown before requests are tried
+// again. A threshold of 0 disables the circuit breaker.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		s.breakerThreshold = threshold
+		s.breakerCooldown = cooldown
+
+		return nil
+	}
+}
+
+// WithSink adds an additional output for alerts alongside any configured
+// Alertmanager(s)
+func WithSink(sink Sink) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if sink == nil {
+			return fmt.Errorf("sink must not be nil")
+		}
+		s.extraSinks = append(s.extraSinks, sink)
+
+		return nil
+	}
+}
+
+// WithSinkQueueSize sets the number of alert batches that may be queued for
+// each sink before batches are dropped
+func WithSinkQueueSize(n int) ServiceSyncServerOption {
+	return func(s *ServiceSyncServer) error {
+		if n < 1 {
+			return fmt.Errorf("sink queue size must be at least 1: %d", n)
+		}
+		s.sinkQueueSize = n
+
+		return nil
+	}
+}
+
 func WithBatchMaxSize(n int) ServiceSyncServerOption {
 	return func(s *ServiceSyncServer) error {
 		s.batchMaxSize = n
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probes holds the result of the last status probe of each Alertmanager
+type probes struct {
+	mu      sync.RWMutex
+	results map[string]bool
+}
+
+func newProbes() *probes {
+	return &probes{
+		results: make(map[string]bool),
+	}
+}
+
+// set replaces the probe results and returns any targets that are no
+// longer present
+func (p *probes) set(results map[string]bool) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := make([]string, 0)
+	for target := range p.results {
+		if _, ok := results[target]; !ok {
+			removed = append(removed, target)
+		}
+	}
+	p.results = results
+
+	return removed
+}
+
+// up returns true if any Alertmanager answered the last probe
+func (p *probes) up() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, up := range p.results {
+		if up {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetServing records whether the gRPC listener is serving, which is used to
+// determine readiness
+func (s *ServiceSyncServer) SetServing(serving bool) {
+	s.serving.Store(serving)
+}
+
+// prober periodically probes the status API of every Alertmanager
+func (s *ServiceSyncServer) prober() {
+	ticker := time.NewTicker(s.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		s.probeAlertmanagers()
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAlertmanagers requests /api/v2/status from every Alertmanager and
+// records which answered
+func (s *ServiceSyncServer) probeAlertmanagers() {
+	urls, err := s.alertmanagerAPIs()
+	if err != nil {
+		s.logger.Warn("problem looking up alertmanagers to probe", "error", err)
+	}
+
+	results := make(map[string]bool, len(urls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.probe(u + "/status")
+			if err != nil {
+				s.logger.Debug("alertmanager probe failed", "url", u, "error", err)
+			}
+
+			mu.Lock()
+			results[u] = err == nil
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for target, up := range results {
+		if up {
+			s.alertmanagerUp.WithLabelValues(target).Set(1)
+		} else {
+			s.alertmanagerUp.WithLabelValues(target).Set(0)
+		}
+	}
+	for _, target := range s.probes.set(results) {
+		s.alertmanagerUp.DeleteLabelValues(target)
+	}
+}
+
+func (s *ServiceSyncServer) probe(url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("bad status code from alertmanager: " + resp.Status)
+	}
+
+	return nil
+}
+
+// ready returns the reasons the receiver is not ready, if any
+func (s *ServiceSyncServer) ready() []string {
+	reasons := make([]string, 0)
+	if !s.running.Load() {
+		reasons = append(reasons, "batch worker is not running")
+	}
+	if !s.serving.Load() {
+		reasons = append(reasons, "gRPC listener is not serving")
+	}
+	if s.alertmanagers != nil && s.probeInterval > 0 && !s.probes.up() {
+		reasons = append(reasons, "no alertmanager answered the last status probe")
+	}
+
+	return reasons
+}
+
+// ReadyHandler returns a handler that responds with 200 OK once the batch
+// worker is running, the gRPC listener is serving and at least one
+// Alertmanager (when configured and probed) answered the last status probe,
+// otherwise 503 Service Unavailable is returned along with the reasons
+func (s *ServiceSyncServer) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reasons := s.ready(); len(reasons) > 0 {
+			http.Error(w, "Not ready: "+strings.Join(reasons, ", "), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Ready"))
+	})
+}
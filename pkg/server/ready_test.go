@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProbeAlertmanagers(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/status" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer up.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	srv, err := NewServiceSyncServer(WithAlertmanagerUrl([]string{up.URL, down.URL}))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	srv.probeAlertmanagers()
+
+	if got := testutil.ToFloat64(srv.alertmanagerUp.WithLabelValues(up.URL + "/api/v2")); got != 1 {
+		t.Errorf("up for %s = %v, want 1", up.URL, got)
+	}
+	if got := testutil.ToFloat64(srv.alertmanagerUp.WithLabelValues(down.URL + "/api/v2")); got != 0 {
+		t.Errorf("up for %s = %v, want 0", down.URL, got)
+	}
+	if !srv.probes.up() {
+		t.Error("probes.up() = false, want true")
+	}
+
+	// targets that are no longer present are removed
+	srv.alertmanagers = func() ([]string, error) {
+		return []string{down.URL + "/api/v2/alerts"}, nil
+	}
+	srv.probeAlertmanagers()
+
+	if got := testutil.CollectAndCount(srv.alertmanagerUp); got != 1 {
+		t.Errorf("up series = %d, want 1", got)
+	}
+	if srv.probes.up() {
+		t.Error("probes.up() = true, want false")
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		running    bool
+		serving    bool
+		probes     map[string]bool
+		noProbe    bool
+		wantStatus int
+	}{
+		{"ready", true, true, map[string]bool{"am-0": true, "am-1": false}, false, http.StatusOK},
+		{"batch worker stopped", false, true, map[string]bool{"am-0": true}, false, http.StatusServiceUnavailable},
+		{"not serving", true, false, map[string]bool{"am-0": true}, false, http.StatusServiceUnavailable},
+		{"no alertmanager up", true, true, map[string]bool{"am-0": false}, false, http.StatusServiceUnavailable},
+		{"never probed", true, true, nil, false, http.StatusServiceUnavailable},
+		{"probing disabled", true, true, nil, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []ServiceSyncServerOption{WithAlertmanagerUrl([]string{"http://am-0:9093"})}
+			if tt.noProbe {
+				opts = append(opts, WithProbeInterval(0))
+			}
+
+			srv, err := NewServiceSyncServer(opts...)
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+			srv.running.Store(tt.running)
+			srv.SetServing(tt.serving)
+			srv.probes.set(tt.probes)
+
+			w := httptest.NewRecorder()
+			srv.ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/ready", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
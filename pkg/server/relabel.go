@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RelabelConfig is a relabeling rule that is compatible with the Prometheus
+// alert_relabel_configs
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	Modulus      uint64   `yaml:"modulus"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+}
+
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelMap  = "labelmap"
+	relabelLabelDrop = "labeldrop"
+	relabelHashMod   = "hashmod"
+)
+
+type relabelRule struct {
+	index        string
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	modulus      uint64
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// newRelabelRules validates the relabel configs and applies the same
+// defaults as Prometheus
+func newRelabelRules(configs []RelabelConfig) ([]relabelRule, error) {
+	rules := make([]relabelRule, 0, len(configs))
+	for n, c := range configs {
+		r := relabelRule{
+			index:        strconv.Itoa(n),
+			sourceLabels: c.SourceLabels,
+			separator:    ";",
+			modulus:      c.Modulus,
+			targetLabel:  c.TargetLabel,
+			replacement:  "$1",
+			action:       strings.ToLower(c.Action),
+		}
+
+		if c.Separator != nil {
+			r.separator = *c.Separator
+		}
+		if c.Replacement != nil {
+			r.replacement = *c.Replacement
+		}
+		if r.action == "" {
+			r.action = relabelReplace
+		}
+
+		expr := "(.*)"
+		if c.Regex != nil {
+			expr = *c.Regex
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid regex: %w", n, err)
+		}
+		r.regex = re
+
+		switch r.action {
+		case relabelReplace:
+			if r.targetLabel == "" {
+				return nil, fmt.Errorf("rule %d: target_label is required for %s", n, r.action)
+			}
+		case relabelHashMod:
+			if !labelNameRegexp.MatchString(r.targetLabel) {
+				return nil, fmt.Errorf("rule %d: invalid target_label %q for %s", n, r.targetLabel, r.action)
+			}
+			if r.modulus == 0 {
+				return nil, fmt.Errorf("rule %d: modulus is required for %s", n, r.action)
+			}
+		case relabelKeep, relabelDrop, relabelLabelMap, relabelLabelDrop:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", n, c.Action)
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// apply runs the rule against labels, modifying them in place. It returns
+// whether the regex of the rule matched and whether the alert should be kept.
+func (r relabelRule) apply(labels map[string]string) (matched, keep bool) {
+	values := make([]string, 0, len(r.sourceLabels))
+	for _, name := range r.sourceLabels {
+		values = append(values, labels[name])
+	}
+	val := strings.Join(values, r.separator)
+
+	switch r.action {
+	case relabelKeep:
+		matched := r.regex.MatchString(val)
+		return matched, matched
+	case relabelDrop:
+		matched := r.regex.MatchString(val)
+		return matched, !matched
+	case relabelReplace:
+		idx := r.regex.FindStringSubmatchIndex(val)
+		if idx == nil {
+			return false, true
+		}
+
+		target := string(r.regex.ExpandString(nil, r.targetLabel, val, idx))
+		if !labelNameRegexp.MatchString(target) {
+			return false, true
+		}
+
+		res := string(r.regex.ExpandString(nil, r.replacement, val, idx))
+		if res == "" {
+			delete(labels, target)
+		} else {
+			labels[target] = res
+		}
+	case relabelHashMod:
+		sum := md5.Sum([]byte(val))
+		labels[r.targetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%r.modulus, 10)
+	case relabelLabelMap:
+		mapped := make(map[string]string)
+		for name, value := range labels {
+			if r.regex.MatchString(name) {
+				mapped[r.regex.ReplaceAllString(name, r.replacement)] = value
+			}
+		}
+		for name, value := range mapped {
+			labels[name] = value
+		}
+
+		return len(mapped) > 0, true
+	case relabelLabelDrop:
+		matched := false
+		for name := range labels {
+			if r.regex.MatchString(name) {
+				delete(labels, name)
+				matched = true
+			}
+		}
+
+		return matched, true
+	}
+
+	return true, true
+}
+
+// relabelMetrics count the alerts matched and dropped by each rule
+type relabelMetrics struct {
+	matched *prometheus.CounterVec
+	dropped *prometheus.CounterVec
+}
+
+func newRelabelMetrics() *relabelMetrics {
+	return &relabelMetrics{
+		matched: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "onmsgrpc_relabel_matched_total",
+			Help: "Total number of alerts matched by a relabel rule.",
+		},
+			[]string{"rule", "action"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "onmsgrpc_relabel_dropped_total",
+			Help: "Total number of alerts dropped by a relabel rule.",
+		},
+			[]string{"rule", "action"}),
+	}
+}
+
+func (m *relabelMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.matched, m.dropped}
+}
+
+// relabel runs all relabel rules against the labels in order, modifying them
+// in place, and returns false if the alert should be dropped
+func (s *ServiceSyncServer) relabel(labels map[string]string) bool {
+	for _, r := range s.relabelRules {
+		matched, keep := r.apply(labels)
+		if matched {
+			s.relabelMetrics.matched.WithLabelValues(r.index, r.action).Inc()
+		}
+		if !keep {
+			s.relabelMetrics.dropped.WithLabelValues(r.index, r.action).Inc()
+			return false
+		}
+	}
+
+	return true
+}
+
+// relabelAlerts runs the relabel rules against each alert and returns the
+// alerts that were not dropped
+func (s *ServiceSyncServer) relabelAlerts(list []Alert) []Alert {
+	kept := list[:0]
+	for _, alert := range list {
+		if s.relabel(alert.Labels) {
+			kept = append(kept, alert)
+		}
+	}
+
+	return kept
+}
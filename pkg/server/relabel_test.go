@@ -0,0 +1,189 @@
+package server
+
+import (
+	"maps"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.yaml.in/yaml/v3"
+)
+
+func TestRelabel(t *testing.T) {
+	base := map[string]string{
+		"alertname":   "uei.opennms.org/nodes/nodeDown",
+		"site":        "perth",
+		"severity":    "major",
+		"instance_id": "uuid",
+	}
+
+	tests := []struct {
+		name     string
+		config   string
+		want     map[string]string
+		wantKeep bool
+	}{
+		{"no rules", "[]", base, true},
+		{"drop internal", `
+- source_labels: [alertname]
+  regex: uei.opennms.org/internal/.*
+  action: drop
+`, base, true},
+		{"drop matched", `
+- source_labels: [alertname]
+  regex: uei.opennms.org/nodes/.*
+  action: drop
+`, nil, false},
+		{"keep unmatched", `
+- source_labels: [severity]
+  regex: critical
+  action: keep
+`, nil, false},
+		{"keep without source labels", `
+- action: keep
+`, base, true},
+		{"drop without source labels", `
+- action: drop
+`, nil, false},
+		{"copy site", `
+- source_labels: [site]
+  target_label: datacenter
+`, map[string]string{
+			"alertname":   "uei.opennms.org/nodes/nodeDown",
+			"site":        "perth",
+			"datacenter":  "perth",
+			"severity":    "major",
+			"instance_id": "uuid",
+		}, true},
+		{"replace with groups", `
+- source_labels: [alertname, severity]
+  separator: "|"
+  regex: uei.opennms.org/nodes/(.*)\|(.*)
+  target_label: event
+  replacement: $1-$2
+`, map[string]string{
+			"alertname":   "uei.opennms.org/nodes/nodeDown",
+			"site":        "perth",
+			"event":       "nodeDown-major",
+			"severity":    "major",
+			"instance_id": "uuid",
+		}, true},
+		{"empty replacement removes", `
+- target_label: site
+  replacement: ""
+`, map[string]string{
+			"alertname":   "uei.opennms.org/nodes/nodeDown",
+			"severity":    "major",
+			"instance_id": "uuid",
+		}, true},
+		{"labelmap", `
+- regex: (site|severity)
+  replacement: onms_$1
+  action: labelmap
+`, map[string]string{
+			"alertname":     "uei.opennms.org/nodes/nodeDown",
+			"site":          "perth",
+			"onms_site":     "perth",
+			"severity":      "major",
+			"onms_severity": "major",
+			"instance_id":   "uuid",
+		}, true},
+		{"labeldrop", `
+- regex: instance_.*
+  action: labeldrop
+`, map[string]string{
+			"alertname": "uei.opennms.org/nodes/nodeDown",
+			"site":      "perth",
+			"severity":  "major",
+		}, true},
+		{"hashmod", `
+- source_labels: [alertname]
+  modulus: 1
+  target_label: shard
+  action: hashmod
+`, map[string]string{
+			"alertname":   "uei.opennms.org/nodes/nodeDown",
+			"site":        "perth",
+			"severity":    "major",
+			"instance_id": "uuid",
+			"shard":       "0",
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configs []RelabelConfig
+			if err := yaml.Unmarshal([]byte(tt.config), &configs); err != nil {
+				t.Fatalf("yaml.Unmarshal() error = %v", err)
+			}
+
+			rules, err := newRelabelRules(configs)
+			if err != nil {
+				t.Fatalf("newRelabelRules() error = %v", err)
+			}
+
+			srv := defaultServiceSyncServer()
+			srv.relabelRules = rules
+			srv.relabelMetrics = newRelabelMetrics()
+
+			got := maps.Clone(base)
+			keep := srv.relabel(got)
+			if keep != tt.wantKeep {
+				t.Fatalf("relabel() = %v, want %v", keep, tt.wantKeep)
+			}
+			if keep && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("relabel() labels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelabelMetrics(t *testing.T) {
+	rules, err := newRelabelRules([]RelabelConfig{
+		{SourceLabels: []string{"severity"}, Regex: ptr("minor"), Action: "drop"},
+	})
+	if err != nil {
+		t.Fatalf("newRelabelRules() error = %v", err)
+	}
+
+	srv := defaultServiceSyncServer()
+	srv.relabelRules = rules
+	srv.relabelMetrics = newRelabelMetrics()
+
+	list := srv.relabelAlerts([]Alert{
+		{Labels: map[string]string{"severity": "minor"}},
+		{Labels: map[string]string{"severity": "major"}},
+	})
+	if len(list) != 1 || list[0].Labels["severity"] != "major" {
+		t.Errorf("relabelAlerts() = %v, want only the major alert", list)
+	}
+
+	if got := testutil.ToFloat64(srv.relabelMetrics.dropped.WithLabelValues("0", "drop")); got != 1 {
+		t.Errorf("dropped = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(srv.relabelMetrics.matched.WithLabelValues("0", "drop")); got != 1 {
+		t.Errorf("matched = %v, want 1", got)
+	}
+}
+
+func TestNewRelabelRulesInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RelabelConfig
+	}{
+		{"unknown action", RelabelConfig{Action: "explode"}},
+		{"invalid regex", RelabelConfig{Regex: ptr("("), TargetLabel: "x"}},
+		{"replace without target", RelabelConfig{Action: "replace"}},
+		{"hashmod without modulus", RelabelConfig{Action: "hashmod", TargetLabel: "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRelabelRules([]RelabelConfig{tt.config}); err == nil {
+				t.Error("newRelabelRules() error = nil, want error")
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// targetGroup is a Prometheus http_sd and file_sd target group
+type targetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+const sdLabelPrefix = "__meta_opennms_"
+
+// targetGroups returns a target group for each node with a primary IP
+// interface. If port is non-zero it is added to each target.
+func (i *inventory) targetGroups(port int) []targetGroup {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	groups := make([]targetGroup, 0)
+	for instanceID, nodes := range i.instances {
+		for _, node := range nodes {
+			ip := ""
+			for _, iface := range node.GetIpInterface() {
+				if iface.GetPrimaryType() == "P" {
+					ip = iface.GetIpAddress()
+					break
+				}
+			}
+			if ip == "" {
+				continue
+			}
+
+			target := ip
+			if port != 0 {
+				target = net.JoinHostPort(ip, strconv.Itoa(port))
+			} else if strings.Contains(ip, ":") {
+				target = "[" + ip + "]"
+			}
+
+			labels := map[string]string{
+				sdLabelPrefix + "instance_id":    instanceID,
+				sdLabelPrefix + "instance_name":  i.names[instanceID],
+				sdLabelPrefix + "node_id":        strconv.FormatUint(node.GetId(), 10),
+				sdLabelPrefix + "node_label":     node.GetLabel(),
+				sdLabelPrefix + "foreign_source": node.GetForeignSource(),
+				sdLabelPrefix + "foreign_id":     node.GetForeignId(),
+				sdLabelPrefix + "location":       node.GetLocation(),
+			}
+
+			// categories are surrounded by separators to simplify matching
+			if categories := node.GetCategory(); len(categories) > 0 {
+				sorted := slices.Sorted(slices.Values(categories))
+				labels[sdLabelPrefix+"categories"] = "," + strings.Join(sorted, ",") + ","
+			}
+
+			groups = append(groups, targetGroup{
+				Targets: []string{target},
+				Labels:  labels,
+			})
+		}
+	}
+
+	// sort for stable output
+	slices.SortFunc(groups, func(a, b targetGroup) int {
+		return cmp.Or(
+			strings.Compare(a.Targets[0], b.Targets[0]),
+			strings.Compare(a.Labels[sdLabelPrefix+"instance_id"], b.Labels[sdLabelPrefix+"instance_id"]),
+		)
+	})
+
+	return groups
+}
+
+// ServiceDiscoveryHandler returns a Prometheus http_sd compatible handler of
+// targets from the inventory. The port added to targets may be overridden
+// with the "port" query parameter.
+func (s *ServiceSyncServer) ServiceDiscoveryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		port := s.sdPort
+		if v := r.URL.Query().Get("port"); v != "" {
+			p, err := strconv.Atoi(v)
+			if err != nil || p < 0 || p > 65535 {
+				http.Error(w, "invalid port", http.StatusBadRequest)
+				return
+			}
+			port = p
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.inventory.targetGroups(port)); err != nil {
+			s.logger.Error("problem encoding targets", "error", err)
+		}
+	})
+}
+
+// fileSDWriter periodically writes the targets from the inventory to the
+// file_sd file when they have changed
+func (s *ServiceSyncServer) fileSDWriter() {
+	var last []byte
+
+	write := func() {
+		b, err := json.MarshalIndent(s.inventory.targetGroups(s.sdPort), "", "  ")
+		if err != nil {
+			s.logger.Error("problem encoding targets", "error", err)
+			return
+		}
+
+		if bytes.Equal(b, last) {
+			return
+		}
+
+		if err := writeFileAtomic(s.sdFile, b); err != nil {
+			s.logger.Error("problem writing file_sd targets", "file", s.sdFile, "error", err)
+			return
+		}
+		last = b
+	}
+
+	ticker := time.NewTicker(s.sdFileInterval)
+	defer ticker.Stop()
+
+	write()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			write()
+		}
+	}
+}
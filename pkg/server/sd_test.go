@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func testSDInventory() *inventory {
+	primary := &pb.IpInterface{}
+	primary.SetIpAddress("192.0.2.1")
+	primary.SetPrimaryType("P")
+
+	secondary := &pb.IpInterface{}
+	secondary.SetIpAddress("192.0.2.2")
+	secondary.SetPrimaryType("S")
+
+	node := testNode(1, "router1")
+	node.SetLabel("router1")
+	node.SetForeignSource("network")
+	node.SetForeignId("r1")
+	node.SetLocation("Default")
+	node.SetCategory([]string{"Routers", "Production"})
+	node.SetIpInterface([]*pb.IpInterface{secondary, primary})
+
+	v6 := &pb.IpInterface{}
+	v6.SetIpAddress("2001:db8::1")
+	v6.SetPrimaryType("P")
+
+	ipv6 := testNode(2, "server1")
+	ipv6.SetLabel("server1")
+	ipv6.SetIpInterface([]*pb.IpInterface{v6})
+
+	// nodes without a primary interface are skipped
+	unmanaged := testNode(3, "unmanaged")
+	unmanaged.SetIpInterface([]*pb.IpInterface{secondary})
+
+	inv := newInventory()
+	inv.update("uuid", "horizon", true, []*pb.Node{node, ipv6, unmanaged})
+
+	return inv
+}
+
+func TestTargetGroups(t *testing.T) {
+	inv := testSDInventory()
+
+	want := []targetGroup{
+		{
+			Targets: []string{"192.0.2.1:9100"},
+			Labels: map[string]string{
+				"__meta_opennms_instance_id":    "uuid",
+				"__meta_opennms_instance_name":  "horizon",
+				"__meta_opennms_node_id":        "1",
+				"__meta_opennms_node_label":     "router1",
+				"__meta_opennms_foreign_source": "network",
+				"__meta_opennms_foreign_id":     "r1",
+				"__meta_opennms_location":       "Default",
+				"__meta_opennms_categories":     ",Production,Routers,",
+			},
+		},
+		{
+			Targets: []string{"[2001:db8::1]:9100"},
+			Labels: map[string]string{
+				"__meta_opennms_instance_id":    "uuid",
+				"__meta_opennms_instance_name":  "horizon",
+				"__meta_opennms_node_id":        "2",
+				"__meta_opennms_node_label":     "server1",
+				"__meta_opennms_foreign_source": "",
+				"__meta_opennms_foreign_id":     "",
+				"__meta_opennms_location":       "",
+			},
+		},
+	}
+
+	if got := inv.targetGroups(9100); !reflect.DeepEqual(got, want) {
+		t.Errorf("targetGroups() = %v, want %v", got, want)
+	}
+
+	// targets have no port by default
+	got := inv.targetGroups(0)
+	if got[0].Targets[0] != "192.0.2.1" || got[1].Targets[0] != "[2001:db8::1]" {
+		t.Errorf("targetGroups() targets = %v, %v, want no port", got[0].Targets, got[1].Targets)
+	}
+}
+
+func TestServiceDiscoveryHandler(t *testing.T) {
+	srv := defaultServiceSyncServer()
+	srv.inventory = testSDInventory()
+	srv.sdPort = 9100
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantTarget string
+	}{
+		{"default port", "", http.StatusOK, "192.0.2.1:9100"},
+		{"override port", "?port=9182", http.StatusOK, "192.0.2.1:9182"},
+		{"invalid port", "?port=http", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			srv.ServiceDiscoveryHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sd"+tt.query, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var groups []targetGroup
+			if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if len(groups) != 2 || groups[0].Targets[0] != tt.wantTarget {
+				t.Errorf("targets = %v, want first target %s", groups, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestFileSDWriter(t *testing.T) {
+	srv := defaultServiceSyncServer()
+	srv.inventory = testSDInventory()
+	srv.sdFile = filepath.Join(t.TempDir(), "targets.json")
+
+	// writes once then returns as the server is stopped
+	srv.Shutdown()
+	srv.fileSDWriter()
+
+	b, err := os.ReadFile(srv.sdFile)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+
+	var groups []targetGroup
+	if err := json.Unmarshal(b, &groups); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if len(groups) != 2 {
+		t.Errorf("file_sd groups = %d, want 2", len(groups))
+	}
+}
@@ -1,22 +1,20 @@
 package server
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
 	"net/url"
-	"strings"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
-	"github.com/go-openapi/strfmt"
-	"github.com/prometheus/alertmanager/api/v2/models"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -34,21 +32,112 @@ type ServiceSyncServer struct {
 	verbose        bool
 	resolveTimeout time.Duration
 	srvCacheTTL    time.Duration
+	srvCache       *srvCache
+	srvName        string
+
+	// retries and circuit breaking
+	sendTimeout      time.Duration
+	retries          int
+	retryBackoff     time.Duration
+	retryMaxBackoff  time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	breakers         *breakers
+
+	// outputs
+	extraSinks    []Sink
+	sinks         []*sinkQueue
+	sinkQueueSize int
+	sinkMetrics   *sinkMetrics
+
+	// alarm fields mapped to labels and annotations
+	labelFields         fieldMapping
+	annotationFields    fieldMapping
+	annotationTemplates annotationTemplates
+
+	// relabeling of outgoing alerts
+	relabelRules   []relabelRule
+	relabelMetrics *relabelMetrics
+
+	// inventory from each instance used to enrich alarms
+	inventory       *inventory
+	nodeLabelFields fieldMapping
+	categoryLabels  *categoryLabels
+	interfaceLabels bool
+
+	// alerts from events
+	eventRules    []eventRule
+	eventAlerts   *eventAlerts
+	ueiNormalizer *ueiNormalizer
+
+	// alarm type handling
+	problemWithoutClear ProblemWithoutClearConfig
+
+	// correlated situations
+	situations *situations
+
+	// service discovery from the inventory
+	sdPort         int
+	sdFile         string
+	sdFileInterval time.Duration
+
+	// silences for acknowledged alarms
+	silences        *ackSilences
+	silenceFile     string
+	silenceDuration time.Duration
+	silenceSync     time.Duration
+
+	// active alarms
+	active          *activeAlarms
+	seen            *seenAlarms
+	refreshInterval time.Duration
+
+	// heartbeat tracking
+	heartbeats       *heartbeats
+	heartbeatTimeout time.Duration
 
 	// metrics
-	alertmanagerTotal  *prometheus.CounterVec
-	alertmanagerErrors *prometheus.CounterVec
-	alarmTotal         *prometheus.CounterVec
-	alarmCount         *prometheus.GaugeVec
-	heartbeatTotal     *prometheus.CounterVec
-	alarmQueueDepth    prometheus.Gauge
-	alarmDropped       prometheus.Counter
-	amLookupErrors     prometheus.Counter
+	alertmanagerTotal   *prometheus.CounterVec
+	alertmanagerErrors  *prometheus.CounterVec
+	alertmanagerRetries *prometheus.CounterVec
+	alertmanagerBreaker *prometheus.GaugeVec
+	alertmanagerUp      *prometheus.GaugeVec
+	alarmTotal          *prometheus.CounterVec
+	alarmCount          *prometheus.GaugeVec
+	inventoryTotal      *prometheus.CounterVec
+	eventTotal          *prometheus.CounterVec
+	eventDelay          *prometheus.HistogramVec
+	inventoryNodes      *prometheus.GaugeVec
+	heartbeatTotal      *prometheus.CounterVec
+	heartbeatLastSeen   *prometheus.GaugeVec
+	alarmQueueDepth     prometheus.Gauge
+	alarmDropped        prometheus.Counter
+	alarmReplayed       prometheus.Counter
+	amLookupErrors      prometheus.Counter
+	alarmActive         prometheus.Gauge
+	snapshotAdded       *prometheus.CounterVec
+	snapshotRemoved     *prometheus.CounterVec
+	streamsRejected     *prometheus.CounterVec
+
+	// readiness
+	running       atomic.Bool
+	serving       atomic.Bool
+	probes        *probes
+	probeInterval time.Duration
 
 	// batching
-	alarmQueue   chan []instanceAlarm
-	batchMaxSize int
-	batchMaxWait time.Duration
+	alarmQueue    chan alarmBatch
+	batchMaxSize  int
+	batchMaxWait  time.Duration
+	pending       *pendingBatch
+	flushRequests chan chan int
+
+	// durable queue
+	queueDir           string
+	queueRetryInterval time.Duration
+	queueMaxAge        time.Duration
+	wal                *wal
+	replay             []alarmBatch
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -56,8 +145,29 @@ type ServiceSyncServer struct {
 	pb.UnimplementedNmsInventoryServiceSyncServer
 }
 
+// alarmBatch is a single update received from a Horizon instance, or from a
+// foreign source for business service states
+type alarmBatch struct {
+	seq           uint64
+	instanceID    string
+	instanceName  string
+	foreignSource string
+	snapshot      bool
+	alarms        []instanceAlarm
+}
+
+// source returns the log attribute identifying where the batch came from
+func (b alarmBatch) source() slog.Attr {
+	if b.foreignSource != "" {
+		return slog.String("foreign_source", b.foreignSource)
+	}
+
+	return slog.String("instance_id", b.instanceID)
+}
+
 type instanceAlarm struct {
 	alarm        *pb.Alarm
+	service      *serviceState
 	now          time.Time
 	instanceID   string
 	instanceName string
@@ -73,6 +183,57 @@ func NewServiceSyncServer(opts ...ServiceSyncServerOption) (*ServiceSyncServer,
 		}
 	}
 
+	// active alarms must be refreshed before alertmanager resolves them
+	if s.refreshInterval >= s.resolveTimeout {
+		refreshInterval := s.resolveTimeout / 2
+		s.logger.Warn("refresh interval is not less than the resolve timeout, using half the resolve timeout instead",
+			"refresh_interval", s.refreshInterval,
+			"resolve_timeout", s.resolveTimeout,
+			"using", refreshInterval,
+		)
+		s.refreshInterval = refreshInterval
+	}
+
+	s.breakers = newBreakers(s.breakerThreshold, s.breakerCooldown)
+
+	s.relabelMetrics = newRelabelMetrics()
+
+	// set up sinks with alertmanager first when configured
+	s.sinkMetrics = newSinkMetrics()
+	sinks := s.extraSinks
+	if s.alertmanagers != nil {
+		sinks = append([]Sink{&alertmanagerSink{srv: s}}, sinks...)
+	}
+	for _, sink := range sinks {
+		s.sinks = append(s.sinks, newSinkQueue(sink, s.sinkQueueSize, s.logger, s.sinkMetrics))
+	}
+
+	// load silences for acknowledged alarms
+	if s.silenceDuration > 0 {
+		if s.alertmanagers == nil {
+			return nil, fmt.Errorf("silences for acknowledged alarms require alertmanager")
+		}
+
+		silences, err := newAckSilences(s.silenceFile, s.silenceDuration)
+		if err != nil {
+			return nil, fmt.Errorf("error loading silences: %w", err)
+		}
+		s.silences = silences
+
+		// sync often enough to renew silences before they end
+		s.silenceSync = max(min(s.silenceSync, s.silenceDuration/4), time.Second)
+	}
+
+	// open durable queue
+	if s.queueDir != "" {
+		w, pending, err := openWAL(s.queueDir)
+		if err != nil {
+			return nil, fmt.Errorf("error opening queue: %w", err)
+		}
+		s.wal = w
+		s.replay = pending
+	}
+
 	// set up metrics
 	s.alertmanagerTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "onmsgrpc_alertmanager_total",
@@ -84,6 +245,21 @@ func NewServiceSyncServer(opts ...ServiceSyncServerOption) (*ServiceSyncServer,
 		Help: "Total number of messages that could not be sent to alertmanager.",
 	},
 		[]string{"alertmanager"})
+	s.alertmanagerRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_alertmanager_retries_total",
+		Help: "Total number of retried requests to alertmanager.",
+	},
+		[]string{"alertmanager"})
+	s.alertmanagerBreaker = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "onmsgrpc_alertmanager_breaker_state",
+		Help: "Current state of the circuit breaker for alertmanager (0 = closed, 1 = half-open, 2 = open).",
+	},
+		[]string{"alertmanager"})
+	s.alertmanagerUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "onmsgrpc_alertmanager_up",
+		Help: "Whether the last status probe of alertmanager succeeded (1 = up, 0 = down).",
+	},
+		[]string{"alertmanager"})
 	s.alarmTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "onmsgrpc_alarm_total",
 		Help: "Total number of alarm updates seen from a Horizon instance.",
@@ -94,11 +270,37 @@ func NewServiceSyncServer(opts ...ServiceSyncServerOption) (*ServiceSyncServer,
 		Help: "Current number of active alarms for a Horizon instance from the last full snapshot of alarms.",
 	},
 		[]string{"instance_id"})
+	s.inventoryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_inventory_total",
+		Help: "Total number of inventory updates seen from a Horizon instance.",
+	},
+		[]string{"instance_id"})
+	s.inventoryNodes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "onmsgrpc_inventory_nodes",
+		Help: "Current number of nodes in the inventory for a Horizon instance.",
+	},
+		[]string{"instance_id"})
+	s.eventTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_event_total",
+		Help: "Total number of events seen from a Horizon instance.",
+	},
+		[]string{"instance_id", "uei", "severity"})
+	s.eventDelay = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "onmsgrpc_event_delay_seconds",
+		Help:    "Delay between the creation of an event and it being received from a Horizon instance.",
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	},
+		[]string{"instance_id"})
 	s.heartbeatTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "onmsgrpc_heartbeat_total",
 		Help: "Total number of heartbeat updates seen from a Horizon instance.",
 	},
 		[]string{"instance_id"})
+	s.heartbeatLastSeen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "onmsgrpc_heartbeat_last_seen_timestamp_seconds",
+		Help: "Unix timestamp of the last heartbeat seen from a Horizon instance.",
+	},
+		[]string{"instance_id"})
 	s.alarmQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "onmsgrpc_alarm_queue_depth",
 		Help: "Current number of alarm batches waiting in the queue.",
@@ -107,10 +309,39 @@ func NewServiceSyncServer(opts ...ServiceSyncServerOption) (*ServiceSyncServer,
 		Name: "onmsgrpc_alarm_dropped_total",
 		Help: "Total number of alarms dropped due to the queue being full.",
 	})
+	s.alarmReplayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "onmsgrpc_alarm_replayed_total",
+		Help: "Total number of alarm batches replayed from the durable queue on startup.",
+	})
 	s.amLookupErrors = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "onmsgrpc_alertmanager_lookup_error_total",
 		Help: "Total number of errors during lookups of Alertmanagers.",
 	})
+	s.alarmActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "onmsgrpc_alarm_active_count",
+		Help: "Current number of active alarms being refreshed to Alertmanager.",
+	})
+	s.snapshotAdded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_snapshot_alarms_added_total",
+		Help: "Total number of alarms present in a snapshot that were not previously seen for a Horizon instance.",
+	},
+		[]string{"instance_id"})
+	s.snapshotRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_snapshot_alarms_removed_total",
+		Help: "Total number of previously seen alarms missing from a snapshot for a Horizon instance.",
+	},
+		[]string{"instance_id"})
+	s.streamsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_grpc_streams_rejected_total",
+		Help: "Total number of gRPC streams rejected by authentication.",
+	},
+		[]string{"reason"})
+
+	s.streamsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "onmsgrpc_grpc_streams_rejected_total",
+		Help: "Total number of gRPC streams rejected by authentication.",
+	},
+		[]string{"reason"})
 
 	// register metrics
 	s.registry.MustRegister(
@@ -118,13 +349,28 @@ func NewServiceSyncServer(opts ...ServiceSyncServerOption) (*ServiceSyncServer,
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		s.alertmanagerTotal,
 		s.alertmanagerErrors,
+		s.alertmanagerRetries,
+		s.alertmanagerBreaker,
+		s.alertmanagerUp,
 		s.alarmTotal,
 		s.alarmCount,
+		s.inventoryTotal,
+		s.inventoryNodes,
+		s.eventTotal,
+		s.eventDelay,
 		s.heartbeatTotal,
+		s.heartbeatLastSeen,
 		s.alarmQueueDepth,
 		s.alarmDropped,
+		s.alarmReplayed,
 		s.amLookupErrors,
+		s.alarmActive,
+		s.snapshotAdded,
+		s.snapshotRemoved,
+		s.streamsRejected,
 	)
+	s.registry.MustRegister(s.sinkMetrics.collectors()...)
+	s.registry.MustRegister(s.relabelMetrics.collectors()...)
 
 	return s, nil
 }
@@ -137,9 +383,21 @@ func defaultServiceSyncServer() *ServiceSyncServer {
 		logger: slog.New(slog.DiscardHandler),
 
 		// basic http client
-		httpClient: &http.Client{
-			Timeout: time.Second * 5,
-		},
+		httpClient: &http.Client{},
+
+		// retry with backoff and stop sending to an alertmanager after 5 failures
+		sendTimeout:      time.Second * 5,
+		retries:          3,
+		retryBackoff:     time.Millisecond * 500,
+		retryMaxBackoff:  time.Second * 10,
+		breakerThreshold: 5,
+		breakerCooldown:  time.Second * 30,
+
+		// queue up to 100 batches per sink
+		sinkQueueSize: 100,
+
+		// default labels
+		labelFields: mustFieldMapping(defaultLabelFields, nil),
 
 		// set up dns client
 		dnsClient: new(net.Resolver),
@@ -153,10 +411,44 @@ func defaultServiceSyncServer() *ServiceSyncServer {
 		// cache SRV records for 30s by default
 		srvCacheTTL: 30 * time.Second,
 
+		// empty inventory
+		inventory: newInventory(),
+
+		// no event rules and full UEIs in event metrics by default
+		eventAlerts:   newEventAlerts(),
+		ueiNormalizer: newUEINormalizer(EventMetricsConfig{}),
+
+		// write file_sd targets every 30s when enabled
+		sdFileInterval: time.Second * 30,
+
+		// re-send active alarms every minute
+		active:          newActiveAlarms(),
+		seen:            newSeenAlarms(),
+		refreshInterval: time.Minute,
+
+		// alert when no heartbeat is seen for 5m
+		heartbeats:       newHeartbeats(),
+		heartbeatTimeout: time.Minute * 5,
+
 		// batching
 		batchMaxSize: 10,
 		batchMaxWait: 20 * time.Second,
-		alarmQueue:   make(chan []instanceAlarm, 100),
+		alarmQueue:   make(chan alarmBatch, 100),
+		pending:      &pendingBatch{},
+
+		// probe alertmanagers every 30s for readiness
+		probes:        newProbes(),
+		probeInterval: time.Second * 30,
+
+		// flush requests from the admin API
+		flushRequests: make(chan chan int),
+
+		// retry undelivered batches every minute for up to a day
+		queueRetryInterval: time.Minute,
+		queueMaxAge:        time.Hour * 24,
+
+		// retry and renew silences for acknowledged alarms every minute
+		silenceSync: time.Minute,
 
 		ctx:    ctx,
 		cancel: cancel,
@@ -170,6 +462,74 @@ func (s *ServiceSyncServer) MetricsHandler() http.Handler {
 }
 
 func (s *ServiceSyncServer) Start() error {
+	// start a worker per sink
+	var wg sync.WaitGroup
+	for _, q := range s.sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.run(s.ctx)
+		}()
+	}
+
+	// manage silences for acknowledged alarms when enabled
+	if s.silences != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.silenceWorker()
+		}()
+	}
+
+	// probe alertmanagers for readiness
+	if s.alertmanagers != nil && s.probeInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.prober()
+		}()
+	}
+
+	// write file_sd targets when enabled
+	if s.sdFile != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.fileSDWriter()
+		}()
+	}
+
+	// wait for sinks to finish with any remaining alerts once stopped
+	defer func() {
+		for _, q := range s.sinks {
+			q.close()
+		}
+		wg.Wait()
+
+		if s.wal != nil {
+			s.wal.close()
+		}
+	}()
+
+	if s.wal == nil {
+		s.batchWorker()
+
+		return nil
+	}
+
+	// replay any batches that were not delivered before the last shutdown
+	if len(s.replay) > 0 {
+		s.logger.Info("replaying queued alarms", "batchcount", len(s.replay))
+		for _, b := range s.replay {
+			if b.snapshot {
+				s.reconcile(b)
+			}
+			s.flush(b.alarms, []uint64{b.seq}, "replay")
+			s.alarmReplayed.Inc()
+		}
+		s.replay = nil
+	}
+
 	s.batchWorker()
 
 	return nil
@@ -220,50 +580,129 @@ func (s *ServiceSyncServer) AlarmUpdate(stream grpc.BidiStreamingServer[pb.Alarm
 			})
 		}
 
-		// enqueue - drop if full (best effort)
+		if err := s.enqueue(alarmBatch{
+			instanceID:   id,
+			instanceName: name,
+			snapshot:     isSnapshot,
+			alarms:       wrapped,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// enqueue adds a batch of alarms to the queue. When the durable queue is
+// enabled the batch is written to disk first and enqueuing blocks until there
+// is room in the queue, otherwise the batch is dropped if the queue is full
+// (best effort).
+func (s *ServiceSyncServer) enqueue(b alarmBatch) error {
+	if s.wal != nil {
+		if err := s.wal.append(&b); err != nil {
+			s.logger.Error("error writing to queue", "instance_id", b.instanceID, "error", err)
+			return err
+		}
+
 		select {
-		case s.alarmQueue <- wrapped:
+		case s.alarmQueue <- b:
 			s.alarmQueueDepth.Set(float64(len(s.alarmQueue)))
-		default:
-			alarmcount := len(alarms)
-			s.logger.Warn("alarm queue full, dropping batch", "alarmcount", alarmcount, "instance_id", id)
-			s.alarmDropped.Add(float64(alarmcount))
+		case <-s.ctx.Done():
+			// batch is replayed from disk on the next startup
 		}
+
+		return nil
 	}
+
+	select {
+	case s.alarmQueue <- b:
+		s.alarmQueueDepth.Set(float64(len(s.alarmQueue)))
+	default:
+		alarmcount := len(b.alarms)
+		s.logger.Warn("alarm queue full, dropping batch", "alarmcount", alarmcount, b.source())
+		s.alarmDropped.Add(float64(alarmcount))
+	}
+
+	return nil
 }
 
 func (s *ServiceSyncServer) batchWorker() {
+	s.running.Store(true)
+	defer s.running.Store(false)
+
 	var batch []instanceAlarm
+	var seqs []uint64
 	timer := time.NewTimer(s.batchMaxWait)
 	defer timer.Stop()
 
+	// a nil channel is never ready so refreshing is disabled when not set
+	var refresh <-chan time.Time
+	if s.refreshInterval > 0 {
+		ticker := time.NewTicker(s.refreshInterval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	// retry batches in the durable queue that could not be delivered
+	var retry <-chan time.Time
+	if s.wal != nil && s.queueRetryInterval > 0 {
+		ticker := time.NewTicker(s.queueRetryInterval)
+		defer ticker.Stop()
+		retry = ticker.C
+	}
+
+	// check for missing heartbeats
+	var heartbeatCheck <-chan time.Time
+	if s.heartbeatTimeout > 0 {
+		ticker := time.NewTicker(s.heartbeatCheckInterval())
+		defer ticker.Stop()
+		heartbeatCheck = ticker.C
+	}
+
 	for {
 		select {
 		case <-s.ctx.Done():
 			if len(batch) > 0 {
-				s.logger.Info("batchWorker: flushing on shutdown", "alarmcount", len(batch))
-				s.handleAlarms(batch)
+				s.flush(batch, seqs, "shutdown")
 				s.alarmQueueDepth.Set(0)
 			}
 			return
 
-		case alarms, ok := <-s.alarmQueue:
+		case b, ok := <-s.alarmQueue:
 			if !ok {
 				// channel closed, flush remainder
 				if len(batch) > 0 {
-					s.logger.Info("batchWorker: flushing on close", "alarmcount", len(batch))
-					s.handleAlarms(batch)
+					s.flush(batch, seqs, "close")
 					s.alarmQueueDepth.Set(0)
 				}
 				return
 			}
 
-			batch = append(batch, alarms...)
+			// snapshots are flushed immediately so alarms are reconciled in order
+			if b.snapshot {
+				if len(batch) > 0 {
+					s.flush(batch, seqs, "before snapshot")
+				}
+
+				s.reconcile(b)
+				s.flush(b.alarms, []uint64{b.seq}, "snapshot")
+				batch = nil
+				seqs = nil
+				s.alarmQueueDepth.Set(float64(len(s.alarmQueue)))
+
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(s.batchMaxWait)
+				continue
+			}
+
+			batch = append(batch, b.alarms...)
+			seqs = append(seqs, b.seq)
+			s.pending.set(batch)
 
 			if len(batch) >= s.batchMaxSize {
-				s.logger.Info("batchWorker: flushing on size", "alarmcount", len(batch))
-				s.handleAlarms(batch)
+				s.flush(batch, seqs, "size")
 				batch = nil
+				seqs = nil
 				s.alarmQueueDepth.Set(float64(len(s.alarmQueue)))
 
 				if !timer.Stop() {
@@ -274,25 +713,180 @@ func (s *ServiceSyncServer) batchWorker() {
 
 		case <-timer.C:
 			if len(batch) > 0 {
-				s.logger.Info("batchWorker: flushing on timer", "alarmcount", len(batch))
-				s.handleAlarms(batch)
+				s.flush(batch, seqs, "timer")
 				batch = nil
+				seqs = nil
 				s.alarmQueueDepth.Set(float64(len(s.alarmQueue)))
 			}
 			timer.Reset(s.batchMaxWait)
+
+		case done := <-s.flushRequests:
+			n := len(batch)
+			if n > 0 {
+				s.flush(batch, seqs, "request")
+				batch = nil
+				seqs = nil
+				s.alarmQueueDepth.Set(float64(len(s.alarmQueue)))
+
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(s.batchMaxWait)
+			}
+			done <- n
+
+		case <-refresh:
+			s.refreshAlarms()
+
+		case <-retry:
+			s.retryQueue()
+
+		case <-heartbeatCheck:
+			s.checkHeartbeats()
 		}
 	}
 }
 
-func (s *ServiceSyncServer) handleAlarms(alarms []instanceAlarm) {
-	list := make([]*models.PostableAlert, 0)
+// flush handles a batch of alarms and, when the durable queue is enabled,
+// acknowledges the batch once it has been accepted by every sink or marks it
+// to be retried
+func (s *ServiceSyncServer) flush(batch []instanceAlarm, seqs []uint64, reason string) {
+	s.logger.Info("batchWorker: flushing on "+reason, "alarmcount", len(batch))
+	s.pending.set(nil)
+
+	s.handleAlarms(batch, func(err error) {
+		if s.wal == nil {
+			return
+		}
+
+		if err != nil {
+			s.wal.fail(seqs)
+			return
+		}
+
+		if err := s.wal.ack(seqs); err != nil {
+			s.logger.Error("error acknowledging queue", "error", err)
+		}
+	})
+}
+
+// retryQueue flushes the batches in the durable queue that could not be
+// delivered, dropping any that are older than the maximum queue age
+func (s *ServiceSyncServer) retryQueue() {
+	var cutoff time.Time
+	if s.queueMaxAge > 0 {
+		cutoff = time.Now().Add(-s.queueMaxAge)
+	}
+
+	batches, dropped, err := s.wal.retry(cutoff)
+	if err != nil {
+		s.logger.Error("error reading queue", "error", err)
+		return
+	}
+
+	if dropped > 0 {
+		s.logger.Warn("dropping undelivered alarms older than the maximum queue age", "alarmcount", dropped, "max_age", s.queueMaxAge)
+		s.alarmDropped.Add(float64(dropped))
+	}
+
+	for _, b := range batches {
+		s.flush(b.alarms, []uint64{b.seq}, "retry")
+	}
+}
+
+// reconcile compares a snapshot against the alarms previously seen for the
+// instance and resolves any active alarms that are no longer present
+func (s *ServiceSyncServer) reconcile(b alarmBatch) {
+	keep := make(map[uint64]bool, len(b.alarms))
+	for _, ia := range b.alarms {
+		keep[ia.alarm.GetId()] = true
+	}
+
+	added, removed := s.seen.replace(b.instanceID, keep)
+	s.snapshotAdded.WithLabelValues(b.instanceID).Add(float64(len(added)))
+	s.snapshotRemoved.WithLabelValues(b.instanceID).Add(float64(len(removed)))
+
+	s.logger.Debug("reconciled snapshot",
+		"instance_id", b.instanceID,
+		"added", len(added),
+		"removed", len(removed),
+	)
+
+	if s.silences != nil {
+		s.silences.retain(b.instanceID, keep)
+	}
+	members := s.situations.retain(b.instanceID, keep)
+
+	resolved := s.active.retain(b.instanceID, keep)
+	s.alarmActive.Set(float64(s.active.len()))
+
+	// resolve alarms missing from the snapshot
+	now := time.Now()
+	for n := range resolved {
+		resolved[n].EndsAt = now
+	}
+
+	if len(resolved) > 0 {
+		s.logger.Info("resolving active alarms missing from snapshot", "instance_id", b.instanceID, "alarmcount", len(resolved))
+	}
+
+	// release the members of situations missing from the snapshot
+	if len(members) > 0 {
+		resolved = append(resolved, s.releaseMembers(b.instanceID, members)...)
+	}
+	if len(resolved) == 0 {
+		return
+	}
+
+	s.send(resolved, nil)
+}
+
+// refreshAlarms re-sends all active alarms with an updated end time so
+// Alertmanager does not resolve alarms that are still open
+func (s *ServiceSyncServer) refreshAlarms() {
+	now := time.Now()
+	list := append(s.active.refresh(now, s.resolveTimeout), s.eventAlerts.refresh(now, s.resolveTimeout)...)
+	if len(list) == 0 {
+		return
+	}
+
+	s.logger.Debug("refreshing active alarms", "alarmcount", len(list))
+
+	s.send(list, nil)
+}
+
+func (s *ServiceSyncServer) handleAlarms(alarms []instanceAlarm, done func(error)) {
+	list := make([]Alert, 0)
 	for _, ia := range alarms {
+		// business service states are handled separately
+		if ia.service != nil {
+			alert, ok := s.serviceAlert(ia)
+			if !ok {
+				continue
+			}
+
+			// drop the alert if required by relabeling
+			if !s.relabel(alert.Labels) {
+				s.active.popService(activeServiceKey{ia.service.foreignType, ia.service.foreignSource, ia.service.foreignService})
+				continue
+			}
+
+			list = append(list, s.updateService(ia.service, alert))
+			continue
+		}
+
 		alarm := ia.alarm
 		id := ia.instanceID
 		name := ia.instanceName
-		now := ia.now
 
-		if s.alertmanagers == nil || s.verbose {
+		// track seen alarms for snapshot reconciliation
+		if alarm.GetSeverity() == uint32(pb.Severity_CLEARED) {
+			s.seen.remove(id, alarm.GetId())
+		} else {
+			s.seen.add(id, alarm.GetId())
+		}
+
+		if len(s.sinks) == 0 || s.verbose {
 			s.logger.Info("AlarmUpdate",
 				"alarm_id", alarm.GetId(),
 				"uei", alarm.GetUei(),
@@ -328,61 +922,105 @@ func (s *ServiceSyncServer) handleAlarms(alarms []instanceAlarm) {
 				"last_update_time", alarm.GetLastUpdateTime(),
 			)
 
-			// finish here if no alertmanagers are configured
-			if s.alertmanagers == nil {
+			// finish here if no sinks are configured
+			if len(s.sinks) == 0 {
 				continue
 			}
 		}
 
+		s.updateSilence(id, alarm)
+
+		// track the members of situations, which are usually cleared without
+		// any members
+		situation := s.situations != nil && (len(alarm.GetRelatedAlarm()) > 0 || s.situations.has(id, alarm.GetId()))
+		if situation {
+			list = append(list, s.updateSituation(id, alarm)...)
+		}
+
+		// clear alarms resolve their problem alarm rather than being sent
+		if alarmType(alarm) == pb.Alarm_CLEAR {
+			if alert, ok := s.clearAlarm(id, alarm); ok {
+				list = append(list, alert)
+			}
+			continue
+		}
+
 		// ignore Normal severity alarms
 		if alarm.GetSeverity() == uint32(pb.Severity_NORMAL) {
+			s.active.delete(id, alarm.GetId())
 			continue
 		}
 
 		firstEventTime := time.UnixMilli(int64(alarm.GetFirstEventTime()))
 		lastEventTime := time.UnixMilli(int64(alarm.GetLastEventTime()))
 
-		if alarm.GetSeverity() != uint32(pb.Severity_CLEARED) && lastEventTime.Before(now.Add(-time.Minute*5)) {
-			// skip cleared alarms older than 5-minutes
-			continue
+		// add mapped fields then instance details
+		labels := make(map[string]string)
+		s.labelFields.apply(alarm, labels)
+		node := s.inventory.node(id, alarm.GetNodeCriteria().GetId())
+		iface := snmpInterface(node, alarm.GetIfIndex())
+		if node != nil {
+			s.nodeLabelFields.apply(node, labels)
+			s.categoryLabels.apply(node, labels)
 		}
-
-		// add basics
-		labels := map[string]string{
-			"alertname":     alarm.GetUei(),
-			"alarm_id":      fmt.Sprint(alarm.GetId()),
-			"node_id":       fmt.Sprint(alarm.GetNodeCriteria().GetId()),
-			"node_name":     alarm.GetNodeCriteria().GetNodeLabel(),
-			"instance_id":   id,
-			"instance_name": name,
-			"severity":      strings.ToLower(pb.Severity_name[int32(alarm.GetSeverity())]),
+		if s.interfaceLabels {
+			interfaceLabels(iface, labels)
 		}
-
-		// add service if set
-		if service := alarm.GetServiceName(); service != "" {
-			labels["service"] = service
-		}
-
-		// add ip_address if set
-		if ip := alarm.GetIpAddress(); ip != "" {
-			labels["ip_address"] = ip
+		labels["instance_id"] = id
+		labels["instance_name"] = name
+
+		// add the situation to situations and their members
+		suppressed := false
+		if situation {
+			labels[situationLabel] = strconv.FormatUint(alarm.GetId(), 10)
+		} else if situationID, ok := s.situations.situation(id, alarm.GetId()); ok {
+			switch s.situations.mode {
+			case situationMembersSuppress:
+				suppressed = true
+			case situationMembersTag:
+				labels[situationLabel] = strconv.FormatUint(situationID, 10)
+			}
 		}
 
-		// set site as node location or site if mapping set
-		if location := alarm.GetNodeCriteria().GetLocation(); location != "" {
-			labels["site"] = location
+		// keep the labels before relabeling so members can be tagged again
+		var unlabeled map[string]string
+		if s.situations != nil {
+			unlabeled = maps.Clone(labels)
 		}
 
-		if rk := alarm.GetReductionKey(); rk != "" {
-			labels["reduction_key"] = rk
+		// drop the alert if required by relabeling
+		if !s.relabel(labels) {
+			s.active.delete(id, alarm.GetId())
+			continue
 		}
 
-		if ck := alarm.GetClearKey(); ck != "" {
-			labels["clear_key"] = ck
+		var annotations map[string]string
+		if len(s.annotationFields) > 0 || len(s.annotationTemplates) > 0 || situation {
+			annotations = make(map[string]string)
+			if situation {
+				situationAnnotations(alarm, annotations)
+			}
+			s.annotationFields.apply(alarm, annotations)
+
+			data := templateData{
+				Alarm:     alarm,
+				Node:      alarm.GetNodeCriteria(),
+				Inventory: node,
+				Interface: iface,
+				Instance:  templateInstance{ID: id, Name: name},
+				Labels:    labels,
+			}
+			if err := s.annotationTemplates.render(data, annotations); err != nil {
+				s.logger.Error("problem rendering annotations", "alarm_id", alarm.GetId(), "error", err)
+			}
 		}
 
-		alert := models.Alert{
-			Labels: labels,
+		// default start and end time based on first event time and now + resolve timeout
+		alert := Alert{
+			Labels:      labels,
+			Annotations: annotations,
+			StartsAt:    firstEventTime,
+			EndsAt:      time.Now().Add(s.resolveTimeout),
 		}
 
 		// add generator URL if mapping set
@@ -392,37 +1030,49 @@ func (s *ServiceSyncServer) handleAlarms(alarms []instanceAlarm) {
 				s.logger.Error("problem creating generatorURL", "error", err)
 				continue
 			}
-			alert.GeneratorURL = strfmt.URI(u + fmt.Sprintf("?id=%d", alarm.GetId()))
+			alert.GeneratorURL = u + fmt.Sprintf("?id=%d", alarm.GetId())
 		}
 
-		// default start and end time based on first event time and now + 5m
-		post := &models.PostableAlert{
-			Alert:    alert,
-			StartsAt: strfmt.DateTime(firstEventTime),
-			EndsAt:   strfmt.DateTime(time.Now().Add(time.Minute * 5)),
+		// set ends at for cleared alerts based on last update time
+		resolveAfter := time.Duration(0)
+		if alarmType(alarm) == pb.Alarm_PROBLEM_WITHOUT_CLEAR {
+			resolveAfter = s.problemWithoutClear.resolveAfter(alarm.GetUei())
+		}
+		switch {
+		case alarm.GetSeverity() == uint32(pb.Severity_CLEARED):
+			alert.EndsAt = lastEventTime
+			s.active.delete(id, alarm.GetId())
+		case resolveAfter > 0:
+			// alarms that are never cleared resolve after a fixed duration
+			alert.EndsAt = lastEventTime.Add(resolveAfter)
+			s.active.delete(id, alarm.GetId())
+		default:
+			s.active.set(id, alarm.GetId(), activeAlarm{
+				alert:        alert,
+				reductionKey: alarm.GetReductionKey(),
+				labels:       unlabeled,
+				suppressed:   suppressed,
+			})
 		}
 
-		// set ends at for cleared alerts based on last update time
-		if alarm.GetSeverity() == uint32(pb.Severity_CLEARED) {
-			post.EndsAt = strfmt.DateTime(lastEventTime)
+		// suppressed members are sent once their situation is removed
+		if suppressed {
+			continue
 		}
 
 		// add to list
-		list = append(list, post)
+		list = append(list, alert)
 	}
 
-	// send to alertmanager at the end
-	if err := s.send(list); err != nil {
-		s.logger.Error("error during send", "error", err)
-	}
-}
+	s.alarmActive.Set(float64(s.active.len()))
 
-// EventUpdate simply accepts and discards any data to avoid errors on the Horizon side
-func (s *ServiceSyncServer) EventUpdate(stream grpc.BidiStreamingServer[pb.EventUpdateList, emptypb.Empty]) error {
-	return discard(stream)
+	// send to sinks at the end
+	s.send(list, done)
 }
 
-func (s *ServiceSyncServer) HeartBeatUpdate(stream grpc.BidiStreamingServer[pb.HeartBeat, emptypb.Empty]) error {
+// EventUpdate records metrics for all events and turns events that match an
+// event rule into alerts
+func (s *ServiceSyncServer) EventUpdate(stream grpc.BidiStreamingServer[pb.EventUpdateList, emptypb.Empty]) error {
 	for {
 		in, err := stream.Recv()
 		if err == io.EOF {
@@ -432,126 +1082,147 @@ func (s *ServiceSyncServer) HeartBeatUpdate(stream grpc.BidiStreamingServer[pb.H
 			return err
 		}
 
-		id := in.GetMonitoringInstance().GetInstanceId()
-		name := in.GetMonitoringInstance().GetInstanceName()
-
-		// increment heartbeat counter
-		s.heartbeatTotal.WithLabelValues(id).Inc()
-
-		// print message
-		s.logger.Info(in.GetMessage(),
-			slog.Group("instance",
-				"id", id,
-				"name", name,
-			),
-			"timestamp", in.GetTimestamp(),
-		)
-
-		// finish here if alertmanager is not set
-		if s.alertmanagers == nil {
-			s.logger.Debug("alertmanager not set")
-			continue
-		}
-
-		// add heartbeat to list
-		labels := map[string]string{
-			"alertname":     "OpenNMSHeartbeat",
-			"instance_id":   id,
-			"instance_name": name,
-		}
-
-		now := time.Now()
+		id := in.GetInstanceId()
+		name := in.GetInstanceName()
+		events := in.GetEvent()
 
-		hb := &models.PostableAlert{
-			Alert: models.Alert{
-				Labels: labels,
-			},
-			StartsAt: strfmt.DateTime(now),
-			EndsAt:   strfmt.DateTime(now.Add(s.resolveTimeout)),
+		if s.verbose {
+			s.logger.Info("EventUpdate",
+				slog.Group("instance",
+					"id", id,
+					"name", name,
+				),
+				"eventcount", len(events),
+			)
 		}
-		s.logger.Debug("adding message to list", "message", hb)
 
-		// send to alertmanager at the end
-		if err := s.send([]*models.PostableAlert{hb}); err != nil {
-			s.logger.Error("error during send", "error", err)
-		}
+		s.observeEvents(id, events, time.Now())
+		s.handleEvents(id, name, events)
 	}
 }
 
-// InventoryUpdate simply accepts and discards any data to avoid errors on the Horizon side
-func (s *ServiceSyncServer) InventoryUpdate(stream grpc.BidiStreamingServer[pb.NmsInventoryUpdateList, emptypb.Empty]) error {
-	return discard(stream)
-}
-
-func discard[T any](stream grpc.BidiStreamingServer[T, emptypb.Empty]) error {
+func (s *ServiceSyncServer) HeartBeatUpdate(stream grpc.BidiStreamingServer[pb.HeartBeat, emptypb.Empty]) error {
 	for {
-		_, err := stream.Recv()
+		in, err := stream.Recv()
 		if err == io.EOF {
 			return nil
 		}
 		if err != nil {
 			return err
 		}
+
+		s.heartbeat(in.GetMonitoringInstance().GetInstanceId(), in.GetMonitoringInstance().GetInstanceName(), in.GetMessage(), in.GetTimestamp())
 	}
 }
 
-func (s *ServiceSyncServer) send(list []*models.PostableAlert) error {
-	if len(list) == 0 {
-		return nil
+// heartbeat handles a heartbeat from either the SPoG or BSM API
+func (s *ServiceSyncServer) heartbeat(id, name, message string, timestamp uint64) {
+	now := time.Now()
+
+	// increment heartbeat counter
+	s.heartbeatTotal.WithLabelValues(id).Inc()
+	s.heartbeatLastSeen.WithLabelValues(id).Set(float64(now.Unix()))
+	wasDown := s.heartbeats.seen(id, name, now)
+
+	// print message
+	s.logger.Info(message,
+		slog.Group("instance",
+			"id", id,
+			"name", name,
+		),
+		"timestamp", timestamp,
+	)
+
+	// finish here if no sinks are configured
+	if len(s.sinks) == 0 {
+		s.logger.Debug("no sinks configured")
+		return
 	}
 
-	ams, err := s.alertmanagers()
-	if err != nil {
-		s.amLookupErrors.Inc()
-		return err
+	// add heartbeat to list
+	labels := map[string]string{
+		"alertname":     "OpenNMSHeartbeat",
+		"instance_id":   id,
+		"instance_name": name,
 	}
 
-	payload, err := json.Marshal(list)
-	if err != nil {
-		return err
+	hb := Alert{
+		Labels:   labels,
+		StartsAt: now,
+		EndsAt:   now.Add(s.resolveTimeout),
 	}
+	s.logger.Debug("adding message to list", "message", hb)
+	list := []Alert{hb}
 
-	logger := s.logger.With("count", len(list))
+	// resolve instance down alert
+	if wasDown {
+		s.logger.Info("heartbeats resumed from instance", "instance_id", id, "instance_name", name)
+		list = append(list, instanceDownAlert(id, name, now, now))
+	}
 
-	var wg sync.WaitGroup
-	for _, am := range ams {
-		wg.Add(1)
-		go func(url string) {
-			defer wg.Done()
+	// send to sinks at the end
+	s.send(s.relabelAlerts(list), nil)
+}
 
-			s.alertmanagerTotal.WithLabelValues(url).Inc()
+// InventoryUpdate stores the nodes from each update so they can be used to
+// enrich alarms
+func (s *ServiceSyncServer) InventoryUpdate(stream grpc.BidiStreamingServer[pb.NmsInventoryUpdateList, emptypb.Empty]) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-			defer cancel()
+		id := in.GetInstanceId()
+		name := in.GetInstanceName()
+		nodes := in.GetNodes()
+		isSnapshot := in.GetSnapshot()
 
-			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
-			if err != nil {
-				logger.Warn("error creating request", "url", url, "error", err)
-				s.alertmanagerErrors.WithLabelValues(url).Inc()
-				return
-			}
-			req.Header.Set("Content-Type", "application/json")
+		count := s.inventory.update(id, name, isSnapshot, nodes)
+		s.inventoryTotal.WithLabelValues(id).Inc()
+		s.inventoryNodes.WithLabelValues(id).Set(float64(count))
 
-			resp, err := s.httpClient.Do(req)
-			if err != nil {
-				logger.Warn("error sending to alertmanager", "url", url, "error", err)
-				s.alertmanagerErrors.WithLabelValues(url).Inc()
-				return
-			}
-			defer resp.Body.Close()
+		s.logger.Info("InventoryUpdate",
+			slog.Group("instance",
+				"id", id,
+				"name", name,
+			),
+			"snapshot", isSnapshot,
+			"nodecount", len(nodes),
+		)
+	}
+}
 
-			if resp.StatusCode != http.StatusOK {
-				logger.Warn("bad status code from alertmanager", "url", url, "status", resp.Status)
-				s.alertmanagerErrors.WithLabelValues(url).Inc()
-				return
-			}
+func discard[T any](stream grpc.BidiStreamingServer[T, emptypb.Empty]) error {
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-			logger.Info("sent to alertmanager", "url", url, "status", resp.Status)
-		}(am)
+// send queues alerts for delivery to every sink. The done function, if not
+// nil, is called with the first error once all sinks have finished with the
+// alerts.
+func (s *ServiceSyncServer) send(list []Alert, done func(error)) {
+	if len(list) == 0 || len(s.sinks) == 0 {
+		if done != nil {
+			done(nil)
+		}
+		return
 	}
-	wg.Wait()
 
-	return nil
+	d := newDelivery(len(s.sinks), done)
+	for _, q := range s.sinks {
+		q.enqueue(list, d.finish)
+	}
 }
 
 func inmap(k string, m map[string]string) string {
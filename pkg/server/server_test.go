@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+func TestShutdownFlush(t *testing.T) {
+	var mu sync.Mutex
+	var received []*models.PostableAlert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alerts []*models.PostableAlert
+		if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, alerts...)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	srv, err := NewServiceSyncServer(
+		WithAlertmanagerUrl([]string{ts.URL}),
+		WithBatchMaxWait(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- srv.Start()
+	}()
+
+	alarm := new(pb.Alarm)
+	alarm.SetId(1)
+	alarm.SetUei("uei.opennms.org/test")
+	alarm.SetSeverity(uint32(pb.Severity_MAJOR))
+	if err := srv.enqueue(alarmBatch{
+		instanceID: "instance",
+		alarms:     []instanceAlarm{{alarm: alarm, instanceID: "instance", now: time.Now()}},
+	}); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+
+	// wait for the batch to be picked up but not sent
+	for len(srv.alarmQueue) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the pending batch is sent on shutdown
+	srv.Shutdown()
+	if err := <-done; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0].Labels["alertname"] != "uei.opennms.org/test" {
+		t.Errorf("received = %v, want the pending alert", received)
+	}
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// ackSilence tracks the Alertmanager silence for an acknowledged alarm
+type ackSilence struct {
+	InstanceID string `json:"instance_id"`
+	AlarmID    uint64 `json:"alarm_id"`
+
+	// AckUser is the user that acknowledged the alarm, which is empty once
+	// the alarm is unacknowledged or cleared and the silence must be expired
+	AckUser string `json:"ack_user"`
+
+	// ID and EndsAt are set once the silence is created
+	ID     string    `json:"id,omitempty"`
+	EndsAt time.Time `json:"ends_at,omitzero"`
+}
+
+// ackSilences is a table of silences for acknowledged alarms, which is
+// saved to a file (when set) so silences are expired after a restart
+type ackSilences struct {
+	mu       sync.Mutex
+	file     string
+	entries  map[activeAlarmKey]ackSilence
+	changed  chan struct{}
+	duration time.Duration
+}
+
+func newAckSilences(file string, duration time.Duration) (*ackSilences, error) {
+	a := &ackSilences{
+		file:     file,
+		entries:  make(map[activeAlarmKey]ackSilence),
+		changed:  make(chan struct{}, 1),
+		duration: duration,
+	}
+
+	if file == "" {
+		return a, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var list []ackSilence
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("invalid silences file: %w", err)
+	}
+	for _, v := range list {
+		a.entries[activeAlarmKey{v.InstanceID, v.AlarmID}] = v
+	}
+
+	return a, nil
+}
+
+// ack records that the alarm is acknowledged by user, or not when user is
+// empty, and signals the silence worker if anything changed
+func (a *ackSilences) ack(instanceID string, alarmID uint64, user string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k := activeAlarmKey{instanceID, alarmID}
+	v, ok := a.entries[k]
+	switch {
+	case !ok && user == "":
+		return
+	case ok && v.AckUser == user:
+		return
+	case ok && v.ID == "" && user == "":
+		// never created so there is nothing to expire
+		delete(a.entries, k)
+		return
+	}
+
+	v.InstanceID = instanceID
+	v.AlarmID = alarmID
+	v.AckUser = user
+
+	// an existing silence is updated for the new user straight away
+	if user != "" {
+		v.EndsAt = time.Time{}
+	}
+	a.entries[k] = v
+
+	a.notify()
+}
+
+// retain marks silences for alarms of the instance that are not in keep to
+// be expired
+func (a *ackSilences) retain(instanceID string, keep map[uint64]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	changed := false
+	for k, v := range a.entries {
+		if k.instanceID != instanceID || keep[k.alarmID] || v.AckUser == "" {
+			continue
+		}
+
+		if v.ID == "" {
+			delete(a.entries, k)
+			continue
+		}
+
+		v.AckUser = ""
+		a.entries[k] = v
+		changed = true
+	}
+
+	if changed {
+		a.notify()
+	}
+}
+
+func (a *ackSilences) notify() {
+	select {
+	case a.changed <- struct{}{}:
+	default:
+	}
+}
+
+// pending returns the silences that must be created, renewed or expired
+func (a *ackSilences) pending(now time.Time) []ackSilence {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	list := make([]ackSilence, 0)
+	for _, v := range a.entries {
+		switch {
+		case v.AckUser == "" && v.ID != "":
+			list = append(list, v)
+		case v.AckUser != "" && (v.ID == "" || v.EndsAt.Sub(now) < a.duration/2):
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// update stores the result of creating or expiring a silence
+func (a *ackSilences) update(v ackSilence, id string, endsAt time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k := activeAlarmKey{v.InstanceID, v.AlarmID}
+	current, ok := a.entries[k]
+	if !ok {
+		return
+	}
+
+	// expired and not acknowledged again in the meantime
+	if id == "" && current.AckUser == "" {
+		delete(a.entries, k)
+		return
+	}
+
+	current.ID = id
+	current.EndsAt = endsAt
+	a.entries[k] = current
+}
+
+func (a *ackSilences) len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return len(a.entries)
+}
+
+// save writes the table to the file, if set
+func (a *ackSilences) save() error {
+	if a.file == "" {
+		return nil
+	}
+
+	a.mu.Lock()
+	list := make([]ackSilence, 0, len(a.entries))
+	for _, v := range a.entries {
+		list = append(list, v)
+	}
+	a.mu.Unlock()
+
+	slices.SortFunc(list, func(x, y ackSilence) int {
+		return cmp.Or(
+			strings.Compare(x.InstanceID, y.InstanceID),
+			cmp.Compare(x.AlarmID, y.AlarmID),
+		)
+	})
+
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(a.file, b)
+}
+
+// updateSilence records the acknowledgement state of an alarm, which is
+// treated as unacknowledged once cleared
+func (s *ServiceSyncServer) updateSilence(instanceID string, alarm *pb.Alarm) {
+	if s.silences == nil {
+		return
+	}
+
+	user := alarm.GetAckUser()
+	if alarm.GetSeverity() == uint32(pb.Severity_CLEARED) || alarm.GetSeverity() == uint32(pb.Severity_NORMAL) || alarmType(alarm) == pb.Alarm_CLEAR {
+		user = ""
+	}
+
+	s.silences.ack(instanceID, alarm.GetId(), user)
+}
+
+// silenceWorker creates, renews and expires silences as alarms are
+// acknowledged, unacknowledged and cleared
+func (s *ServiceSyncServer) silenceWorker() {
+	ticker := time.NewTicker(s.silenceSync)
+	defer ticker.Stop()
+
+	for {
+		s.syncSilences(time.Now())
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.silences.changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncSilences makes any pending changes to silences in Alertmanager
+func (s *ServiceSyncServer) syncSilences(now time.Time) {
+	list := s.silences.pending(now)
+	if len(list) == 0 {
+		return
+	}
+
+	for _, v := range list {
+		logger := s.logger.With("instance_id", v.InstanceID, "alarm_id", v.AlarmID)
+
+		if v.AckUser == "" {
+			if err := s.expireSilence(v.ID); err != nil {
+				logger.Warn("problem expiring silence", "silence_id", v.ID, "error", err)
+				continue
+			}
+			logger.Info("expired silence for alarm", "silence_id", v.ID)
+			s.silences.update(v, "", time.Time{})
+			continue
+		}
+
+		endsAt := now.Add(s.silences.duration)
+		id, err := s.postSilence(v, now, endsAt)
+		if err != nil {
+			logger.Warn("problem creating silence", "error", err)
+			continue
+		}
+		logger.Info("created silence for alarm", "ack_user", v.AckUser, "silence_id", id)
+		s.silences.update(v, id, endsAt)
+	}
+
+	if err := s.silences.save(); err != nil {
+		s.logger.Error("problem saving silences", "file", s.silences.file, "error", err)
+	}
+}
+
+// postSilence creates or updates the silence for an acknowledged alarm via
+// the first Alertmanager that accepts it, as silences are shared within an
+// Alertmanager cluster
+func (s *ServiceSyncServer) postSilence(v ackSilence, startsAt, endsAt time.Time) (string, error) {
+	urls, err := s.alertmanagerAPIs()
+	if err != nil {
+		return "", err
+	}
+
+	isEqual := true
+	isRegex := false
+	comment := fmt.Sprintf("Alarm %d acknowledged in OpenNMS", v.AlarmID)
+	createdBy := v.AckUser
+	start := strfmt.DateTime(startsAt)
+	end := strfmt.DateTime(endsAt)
+	instanceLabel := "instance_id"
+	alarmLabel := "alarm_id"
+	instanceID := v.InstanceID
+	alarmID := strconv.FormatUint(v.AlarmID, 10)
+
+	payload, err := json.Marshal(models.PostableSilence{
+		ID: v.ID,
+		Silence: models.Silence{
+			Comment:   &comment,
+			CreatedBy: &createdBy,
+			StartsAt:  &start,
+			EndsAt:    &end,
+			Matchers: models.Matchers{
+				{Name: &instanceLabel, Value: &instanceID, IsEqual: &isEqual, IsRegex: &isRegex},
+				{Name: &alarmLabel, Value: &alarmID, IsEqual: &isEqual, IsRegex: &isRegex},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var errs []error
+	for _, u := range urls {
+		id, err := s.silenceRequest(http.MethodPost, u+"/silences", payload)
+		if err == nil {
+			return id, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// expireSilence expires a silence via the first Alertmanager that accepts
+// the request. A silence that is not found is treated as expired.
+func (s *ServiceSyncServer) expireSilence(id string) error {
+	urls, err := s.alertmanagerAPIs()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, u := range urls {
+		_, err := s.silenceRequest(http.MethodDelete, u+"/silence/"+id, nil)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *ServiceSyncServer) silenceRequest(method, url string, payload []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if method == http.MethodDelete && resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status code from alertmanager: %s", resp.Status)
+	}
+
+	if method != http.MethodPost {
+		return "", nil
+	}
+
+	var body struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid response from alertmanager: %w", err)
+	}
+
+	return body.SilenceID, nil
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// testSilenceAlertmanager records silence requests, returning the alarm ID
+// of each created silence as its silence ID
+type testSilenceAlertmanager struct {
+	mu       sync.Mutex
+	requests []string
+	posted   []models.PostableSilence
+}
+
+func (t *testSilenceAlertmanager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.requests = append(t.requests, r.Method+" "+r.URL.Path)
+
+	if r.Method == http.MethodPost {
+		var silence models.PostableSilence
+		if err := json.NewDecoder(r.Body).Decode(&silence); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		t.posted = append(t.posted, silence)
+
+		json.NewEncoder(w).Encode(map[string]string{"silenceID": "s-" + *silence.Matchers[1].Value})
+	}
+}
+
+func (t *testSilenceAlertmanager) reset() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := t.requests
+	t.requests = nil
+
+	return list
+}
+
+func testAckAlarm(ackUser string, severity pb.Severity) *pb.Alarm {
+	alarm := testAlarm()
+	alarm.SetAckUser(ackUser)
+	alarm.SetSeverity(uint32(severity))
+	alarm.SetLastEventTime(uint64(time.Now().UnixMilli()))
+
+	return alarm
+}
+
+func TestAckSilences(t *testing.T) {
+	tests := []struct {
+		name   string
+		alarms []*pb.Alarm
+		want   []string
+	}{
+		{"not acknowledged", []*pb.Alarm{testAckAlarm("", pb.Severity_MAJOR)}, nil},
+		{"acknowledged", []*pb.Alarm{testAckAlarm("admin", pb.Severity_MAJOR)}, []string{"POST /api/v2/silences"}},
+		{"acknowledged again", []*pb.Alarm{testAckAlarm("admin", pb.Severity_MAJOR), testAckAlarm("admin", pb.Severity_MAJOR)}, []string{"POST /api/v2/silences"}},
+		{"unacknowledged", []*pb.Alarm{testAckAlarm("admin", pb.Severity_MAJOR), testAckAlarm("", pb.Severity_MAJOR)}, []string{"POST /api/v2/silences", "DELETE /api/v2/silence/s-42"}},
+		{"cleared", []*pb.Alarm{testAckAlarm("admin", pb.Severity_MAJOR), testAckAlarm("admin", pb.Severity_CLEARED)}, []string{"POST /api/v2/silences", "DELETE /api/v2/silence/s-42"}},
+		{"other user", []*pb.Alarm{testAckAlarm("admin", pb.Severity_MAJOR), testAckAlarm("operator", pb.Severity_MAJOR)}, []string{"POST /api/v2/silences", "POST /api/v2/silences"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			am := &testSilenceAlertmanager{}
+			ts := httptest.NewServer(am)
+			defer ts.Close()
+
+			srv, err := NewServiceSyncServer(WithAlertmanagerUrl([]string{ts.URL}), WithAckSilences("", time.Hour))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			for _, alarm := range tt.alarms {
+				srv.handleAlarms([]instanceAlarm{{alarm: alarm, instanceID: "instance1", now: time.Now()}}, nil)
+				srv.syncSilences(time.Now())
+			}
+
+			if got := am.reset(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("requests = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAckSilencesRequest(t *testing.T) {
+	am := &testSilenceAlertmanager{}
+	ts := httptest.NewServer(am)
+	defer ts.Close()
+
+	srv, err := NewServiceSyncServer(WithAlertmanagerUrl([]string{ts.URL}), WithAckSilences("", time.Hour))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	srv.handleAlarms([]instanceAlarm{{alarm: testAckAlarm("admin", pb.Severity_MAJOR), instanceID: "instance1", now: time.Now()}}, nil)
+	srv.syncSilences(time.Now())
+
+	if len(am.posted) != 1 {
+		t.Fatalf("posted %d silences, want 1", len(am.posted))
+	}
+
+	silence := am.posted[0]
+	if *silence.CreatedBy != "admin" {
+		t.Errorf("createdBy = %q, want %q", *silence.CreatedBy, "admin")
+	}
+	matchers := make(map[string]string)
+	for _, m := range silence.Matchers {
+		matchers[*m.Name] = *m.Value
+	}
+	if matchers["instance_id"] != "instance1" || matchers["alarm_id"] != "42" || len(matchers) != 2 {
+		t.Errorf("matchers = %v, want instance_id and alarm_id", matchers)
+	}
+
+	// silences are renewed once half their duration has passed
+	srv.syncSilences(time.Now().Add(time.Minute * 20))
+	if got := am.reset(); len(got) != 1 {
+		t.Errorf("requests before renewal = %v, want only the initial request", got)
+	}
+	srv.syncSilences(time.Now().Add(time.Minute * 40))
+	if got := am.reset(); len(got) != 1 || am.posted[1].ID != "s-42" {
+		t.Errorf("requests for renewal = %v, want an update of s-42", got)
+	}
+}
+
+func TestAckSilencesRestart(t *testing.T) {
+	am := &testSilenceAlertmanager{}
+	ts := httptest.NewServer(am)
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "silences.json")
+
+	srv, err := NewServiceSyncServer(WithAlertmanagerUrl([]string{ts.URL}), WithAckSilences(file, time.Hour))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	srv.handleAlarms([]instanceAlarm{{alarm: testAckAlarm("admin", pb.Severity_MAJOR), instanceID: "instance1", now: time.Now()}}, nil)
+	srv.syncSilences(time.Now())
+	am.reset()
+
+	// the silence is expired after a restart once the alarm is missing from a snapshot
+	srv, err = NewServiceSyncServer(WithAlertmanagerUrl([]string{ts.URL}), WithAckSilences(file, time.Hour))
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	if got := srv.silences.len(); got != 1 {
+		t.Fatalf("silences after restart = %d, want 1", got)
+	}
+
+	srv.reconcile(alarmBatch{instanceID: "instance1", snapshot: true})
+	srv.syncSilences(time.Now())
+
+	if got := am.reset(); len(got) != 1 || got[0] != "DELETE /api/v2/silence/s-42" {
+		t.Errorf("requests = %v, want silence s-42 expired", got)
+	}
+	if got := srv.silences.len(); got != 0 {
+		t.Errorf("silences after expiry = %d, want 0", got)
+	}
+}
+
+func TestSilenceWorkerRefreshDisabled(t *testing.T) {
+	am := &testSilenceAlertmanager{}
+	ts := httptest.NewServer(am)
+	defer ts.Close()
+
+	srv, err := NewServiceSyncServer(
+		WithAlertmanagerUrl([]string{ts.URL}),
+		WithAckSilences("", time.Hour),
+		WithRefreshInterval(0),
+		WithProbeInterval(0),
+	)
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- srv.Start()
+	}()
+
+	// silences are still created when refreshing alarms is disabled
+	srv.handleAlarms([]instanceAlarm{{alarm: testAckAlarm("admin", pb.Severity_MAJOR), instanceID: "instance1", now: time.Now()}}, nil)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for !slices.Contains(am.reset(), "POST /api/v2/silences") {
+		if time.Now().After(deadline) {
+			t.Fatal("no silence created")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	srv.Shutdown()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error = %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Alert is the normalized alert model passed to a Sink
+type Alert struct {
+	Labels       map[string]string
+	Annotations  map[string]string
+	StartsAt     time.Time
+	EndsAt       time.Time
+	GeneratorURL string
+}
+
+// A Sink is an output for alerts. Each sink has its own queue so a slow sink
+// does not hold up any others.
+type Sink interface {
+	// Name is used to identify the sink in logs and metrics
+	Name() string
+
+	// Send delivers a batch of alerts. The context is cancelled when the
+	// server is shutting down.
+	Send(ctx context.Context, alerts []Alert) error
+}
+
+var errSinkQueueFull = errors.New("sink queue full")
+
+// sinkMetrics are shared by all sinks and labelled by sink name
+type sinkMetrics struct {
+	total    *prometheus.CounterVec
+	failed   *prometheus.CounterVec
+	dropped  *prometheus.CounterVec
+	depth    *prometheus.GaugeVec
+	duration *prometheus.HistogramVec
+}
+
+func newSinkMetrics() *sinkMetrics {
+	return &sinkMetrics{
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "onmsgrpc_sink_alerts_total",
+			Help: "Total number of alerts sent to a sink.",
+		},
+			[]string{"sink"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "onmsgrpc_sink_failed_total",
+			Help: "Total number of alerts that could not be sent to a sink.",
+		},
+			[]string{"sink"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "onmsgrpc_sink_dropped_total",
+			Help: "Total number of alerts dropped due to the queue for a sink being full.",
+		},
+			[]string{"sink"}),
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "onmsgrpc_sink_queue_depth",
+			Help: "Current number of alert batches waiting in the queue for a sink.",
+		},
+			[]string{"sink"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "onmsgrpc_sink_send_duration_seconds",
+			Help:    "Time taken to send a batch of alerts to a sink.",
+			Buckets: prometheus.DefBuckets,
+		},
+			[]string{"sink"}),
+	}
+}
+
+func (m *sinkMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.total, m.failed, m.dropped, m.depth, m.duration}
+}
+
+type sinkItem struct {
+	alerts []Alert
+	done   func(error)
+}
+
+// sinkQueue queues batches of alerts for a single sink
+type sinkQueue struct {
+	sink    Sink
+	logger  *slog.Logger
+	metrics *sinkMetrics
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan sinkItem
+}
+
+func newSinkQueue(sink Sink, size int, logger *slog.Logger, metrics *sinkMetrics) *sinkQueue {
+	return &sinkQueue{
+		sink:    sink,
+		logger:  logger.With("sink", sink.Name()),
+		metrics: metrics,
+		queue:   make(chan sinkItem, size),
+	}
+}
+
+// enqueue adds a batch of alerts to the queue, dropping the batch if the
+// queue is full or closed. The done function is called once the batch has
+// been sent or dropped.
+func (q *sinkQueue) enqueue(alerts []Alert, done func(error)) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	name := q.sink.Name()
+	if !q.closed {
+		select {
+		case q.queue <- sinkItem{alerts: alerts, done: done}:
+			q.metrics.depth.WithLabelValues(name).Set(float64(len(q.queue)))
+			return
+		default:
+		}
+	}
+
+	q.logger.Warn("sink queue full, dropping alerts", "count", len(alerts))
+	q.metrics.dropped.WithLabelValues(name).Add(float64(len(alerts)))
+	done(errSinkQueueFull)
+}
+
+// run sends queued alerts to the sink until the queue is closed
+func (q *sinkQueue) run(ctx context.Context) {
+	name := q.sink.Name()
+	for item := range q.queue {
+		q.metrics.depth.WithLabelValues(name).Set(float64(len(q.queue)))
+
+		start := time.Now()
+		err := q.sink.Send(ctx, item.alerts)
+		q.metrics.duration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		q.metrics.total.WithLabelValues(name).Add(float64(len(item.alerts)))
+		if err != nil {
+			q.logger.Error("error during send", "count", len(item.alerts), "error", err)
+			q.metrics.failed.WithLabelValues(name).Add(float64(len(item.alerts)))
+		}
+
+		item.done(err)
+	}
+}
+
+func (q *sinkQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+}
+
+func (q *sinkQueue) depth() int {
+	return len(q.queue)
+}
+
+// delivery collects the result of sending a batch of alerts to a number of
+// sinks and calls done with the first error once all sinks have finished
+type delivery struct {
+	mu      sync.Mutex
+	pending int
+	err     error
+	done    func(error)
+}
+
+func newDelivery(sinks int, done func(error)) *delivery {
+	return &delivery{
+		pending: sinks,
+		done:    done,
+	}
+}
+
+func (d *delivery) finish(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+
+	d.pending--
+	if d.pending == 0 && d.done != nil {
+		d.done(d.err)
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSink struct {
+	name  string
+	err   error
+	block chan struct{}
+
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (t *testSink) Name() string {
+	return t.name
+}
+
+func (t *testSink) Send(ctx context.Context, alerts []Alert) error {
+	if t.block != nil {
+		select {
+		case <-t.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.alerts = append(t.alerts, alerts...)
+
+	return t.err
+}
+
+func (t *testSink) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.alerts)
+}
+
+func TestSinkQueueSlowSink(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metrics := newSinkMetrics()
+	slow := &testSink{name: "slow", block: make(chan struct{})}
+	fast := &testSink{name: "fast"}
+	sq := newSinkQueue(slow, 1, slog.Default(), metrics)
+	fq := newSinkQueue(fast, 1, slog.Default(), metrics)
+	go sq.run(ctx)
+	go fq.run(ctx)
+	defer sq.close()
+	defer fq.close()
+
+	// the fast sink receives alerts while the slow sink is blocked
+	done := make(chan error, 1)
+	d := newDelivery(2, func(err error) { done <- err })
+	sq.enqueue([]Alert{testAlert("one")}, d.finish)
+	fq.enqueue([]Alert{testAlert("one")}, d.finish)
+
+	deadline := time.After(time.Second)
+	for fast.count() != 1 {
+		select {
+		case <-deadline:
+			t.Fatal("fast sink did not receive alerts")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// delivery only completes once every sink has finished
+	select {
+	case err := <-done:
+		t.Fatalf("delivery finished early with %v", err)
+	default:
+	}
+
+	close(slow.block)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("delivery error = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delivery did not finish")
+	}
+}
+
+func TestSinkQueueFull(t *testing.T) {
+	sink := &testSink{name: "test"}
+	q := newSinkQueue(sink, 1, slog.Default(), newSinkMetrics())
+
+	// queue is not being drained so the second batch is dropped
+	var errs []error
+	q.enqueue([]Alert{testAlert("one")}, func(err error) { errs = append(errs, err) })
+	q.enqueue([]Alert{testAlert("two")}, func(err error) { errs = append(errs, err) })
+	if len(errs) != 1 || !errors.Is(errs[0], errSinkQueueFull) {
+		t.Errorf("enqueue() errors = %v, want [%v]", errs, errSinkQueueFull)
+	}
+
+	// a closed queue drops everything
+	q.close()
+	q.enqueue([]Alert{testAlert("three")}, func(err error) { errs = append(errs, err) })
+	if len(errs) != 2 || !errors.Is(errs[1], errSinkQueueFull) {
+		t.Errorf("enqueue() after close errors = %v, want %v", errs, errSinkQueueFull)
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	errFirst := errors.New("first")
+	errSecond := errors.New("second")
+
+	tests := []struct {
+		name    string
+		results []error
+		want    error
+	}{
+		{"all ok", []error{nil, nil}, nil},
+		{"one failed", []error{nil, errFirst}, errFirst},
+		{"first error kept", []error{errFirst, errSecond}, errFirst},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var got error
+			d := newDelivery(len(tt.results), func(err error) {
+				calls++
+				got = err
+			})
+
+			for _, err := range tt.results {
+				d.finish(err)
+			}
+
+			if calls != 1 {
+				t.Fatalf("done called %d times, want 1", calls)
+			}
+			if got != tt.want {
+				t.Errorf("done error = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
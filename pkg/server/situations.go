@@ -0,0 +1,311 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+// SituationConfig controls how situations, which are alarms that OpenNMS
+// has correlated from other alarms, are sent
+type SituationConfig struct {
+	// Enabled adds the member alarms of a situation as annotations and the
+	// situation_id label to the alert for the situation
+	Enabled bool `yaml:"enabled"`
+
+	// Members is "tag" to add the situation_id label to the alerts for
+	// member alarms or "suppress" to not send them. By default alerts for
+	// member alarms are sent unchanged.
+	Members string `yaml:"members"`
+}
+
+const (
+	situationMembersTag      = "tag"
+	situationMembersSuppress = "suppress"
+
+	situationLabel = "situation_id"
+)
+
+// situations tracks the member alarms of each situation
+type situations struct {
+	mu      sync.Mutex
+	mode    string
+	members map[activeAlarmKey]uint64
+	related map[activeAlarmKey][]uint64
+}
+
+func newSituations(c SituationConfig) (*situations, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	switch c.Members {
+	case "", situationMembersTag, situationMembersSuppress:
+	default:
+		return nil, fmt.Errorf("invalid members mode: %s", c.Members)
+	}
+
+	return &situations{
+		mode:    c.Members,
+		members: make(map[activeAlarmKey]uint64),
+		related: make(map[activeAlarmKey][]uint64),
+	}, nil
+}
+
+// set replaces the members of a situation and returns any alarms that were
+// not previously members along with any that are no longer members
+func (t *situations) set(instanceID string, situationID uint64, members []uint64) ([]uint64, []uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k := activeAlarmKey{instanceID, situationID}
+	previous := make(map[uint64]bool, len(t.related[k]))
+	for _, id := range t.related[k] {
+		previous[id] = t.members[activeAlarmKey{instanceID, id}] == situationID
+	}
+	t.remove(k)
+
+	added := make([]uint64, 0)
+	for _, id := range members {
+		if !previous[id] {
+			added = append(added, id)
+		}
+		delete(previous, id)
+		t.members[activeAlarmKey{instanceID, id}] = situationID
+	}
+	t.related[k] = members
+
+	removed := make([]uint64, 0, len(previous))
+	for id, member := range previous {
+		if member {
+			removed = append(removed, id)
+		}
+	}
+
+	return added, removed
+}
+
+// delete removes a situation and returns its members
+func (t *situations) delete(instanceID string, situationID uint64) []uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k := activeAlarmKey{instanceID, situationID}
+	removed := t.current(k)
+	t.remove(k)
+
+	return removed
+}
+
+// current returns the alarms that are members of the situation, as an
+// alarm only belongs to the last situation it was added to, the lock must be
+// held
+func (t *situations) current(k activeAlarmKey) []uint64 {
+	members := make([]uint64, 0, len(t.related[k]))
+	for _, id := range t.related[k] {
+		if t.members[activeAlarmKey{k.instanceID, id}] == k.alarmID {
+			members = append(members, id)
+		}
+	}
+
+	return members
+}
+
+// has returns true if the alarm is a known situation
+func (t *situations) has(instanceID string, alarmID uint64) bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.related[activeAlarmKey{instanceID, alarmID}]
+
+	return ok
+}
+
+// retain removes all situations for the instance that are not in keep and
+// returns their members
+func (t *situations) retain(instanceID string, keep map[uint64]bool) []uint64 {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	removed := make([]uint64, 0)
+	for k := range t.related {
+		if k.instanceID == instanceID && !keep[k.alarmID] {
+			removed = append(removed, t.current(k)...)
+			t.remove(k)
+		}
+	}
+
+	return removed
+}
+
+// remove deletes a situation and its members, the lock must be held
+func (t *situations) remove(k activeAlarmKey) {
+	for _, id := range t.related[k] {
+		mk := activeAlarmKey{k.instanceID, id}
+		if t.members[mk] == k.alarmID {
+			delete(t.members, mk)
+		}
+	}
+	delete(t.related, k)
+}
+
+// situation returns the situation that the alarm is a member of
+func (t *situations) situation(instanceID string, alarmID uint64) (uint64, bool) {
+	if t == nil {
+		return 0, false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id, ok := t.members[activeAlarmKey{instanceID, alarmID}]
+
+	return id, ok
+}
+
+// situationAnnotations lists the member alarms of a situation
+func situationAnnotations(alarm *pb.Alarm, annotations map[string]string) {
+	ids := make([]string, 0, len(alarm.GetRelatedAlarm()))
+	lines := make([]string, 0, len(alarm.GetRelatedAlarm()))
+	for _, related := range alarm.GetRelatedAlarm() {
+		id := strconv.FormatUint(related.GetId(), 10)
+		ids = append(ids, id)
+
+		line := id + ": " + related.GetUei()
+		if label := related.GetNodeCriteria().GetNodeLabel(); label != "" {
+			line += " (" + label + ")"
+		}
+		lines = append(lines, line)
+	}
+
+	annotations["related_alarm_ids"] = strings.Join(ids, ",")
+	annotations["related_alarms"] = strings.Join(lines, "\n")
+}
+
+// updateSituation records the members of a situation and returns the
+// alerts for any active member alarms that must be re-sent, which are either
+// tagged with the situation or resolved when suppressed. Situations that are
+// cleared, or no longer have any members, are removed and their members are
+// released.
+func (s *ServiceSyncServer) updateSituation(instanceID string, alarm *pb.Alarm) []Alert {
+	var added, removed []uint64
+	if alarm.GetSeverity() == uint32(pb.Severity_CLEARED) || len(alarm.GetRelatedAlarm()) == 0 {
+		removed = s.situations.delete(instanceID, alarm.GetId())
+	} else {
+		members := make([]uint64, 0, len(alarm.GetRelatedAlarm()))
+		for _, related := range alarm.GetRelatedAlarm() {
+			members = append(members, related.GetId())
+		}
+
+		added, removed = s.situations.set(instanceID, alarm.GetId(), members)
+	}
+
+	list := make([]Alert, 0)
+	situationID := strconv.FormatUint(alarm.GetId(), 10)
+	for _, id := range added {
+		switch s.situations.mode {
+		case situationMembersTag:
+			list = append(list, s.tagMember(instanceID, id, situationID)...)
+		case situationMembersSuppress:
+			if alert, ok := s.suppressMember(instanceID, id); ok {
+				list = append(list, alert)
+			}
+		}
+	}
+
+	return append(list, s.releaseMembers(instanceID, removed)...)
+}
+
+// releaseMembers returns the alerts for active alarms that are no longer
+// members of a situation, which have the situation label removed or are sent
+// again if they were suppressed
+func (s *ServiceSyncServer) releaseMembers(instanceID string, alarmIDs []uint64) []Alert {
+	list := make([]Alert, 0)
+	for _, id := range alarmIDs {
+		switch s.situations.mode {
+		case situationMembersTag:
+			list = append(list, s.tagMember(instanceID, id, "")...)
+		case situationMembersSuppress:
+			v, ok := s.active.get(instanceID, id)
+			if !ok || !v.suppressed {
+				continue
+			}
+
+			v.suppressed = false
+			v.alert.EndsAt = time.Now().Add(s.resolveTimeout)
+			s.active.set(instanceID, id, v)
+
+			list = append(list, v.alert)
+		}
+	}
+
+	return list
+}
+
+// suppressMember marks the active alarm as suppressed so it is no longer
+// re-sent and returns its alert as resolved
+func (s *ServiceSyncServer) suppressMember(instanceID string, alarmID uint64) (Alert, bool) {
+	v, ok := s.active.get(instanceID, alarmID)
+	if !ok || v.suppressed {
+		return Alert{}, false
+	}
+
+	v.suppressed = true
+	v.alert.EndsAt = time.Now()
+	s.active.set(instanceID, alarmID, v)
+
+	return v.alert, true
+}
+
+// tagMember sets the situation label of the alert for an active member
+// alarm, or removes it when situationID is empty, and returns the previous
+// alert as resolved along with the relabeled alert as the change of labels
+// results in a new alert in Alertmanager
+func (s *ServiceSyncServer) tagMember(instanceID string, alarmID uint64, situationID string) []Alert {
+	v, ok := s.active.get(instanceID, alarmID)
+	if !ok {
+		return nil
+	}
+
+	previous := v.alert
+	previous.EndsAt = time.Now()
+
+	// tag the labels from before relabeling so the rules see the situation
+	labels := maps.Clone(v.labels)
+	if situationID == "" {
+		delete(labels, situationLabel)
+	} else {
+		labels[situationLabel] = situationID
+	}
+	unlabeled := maps.Clone(labels)
+
+	// drop the alert if required by relabeling
+	if !s.relabel(labels) {
+		s.active.delete(instanceID, alarmID)
+		return []Alert{previous}
+	}
+
+	v.labels = unlabeled
+	if maps.Equal(labels, v.alert.Labels) {
+		s.active.set(instanceID, alarmID, v)
+		return nil
+	}
+
+	v.alert.Labels = labels
+	s.active.set(instanceID, alarmID, v)
+
+	return []Alert{previous, v.alert}
+}
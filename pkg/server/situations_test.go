@@ -0,0 +1,229 @@
+package server
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func testSituation(members ...*pb.Alarm) *pb.Alarm {
+	situation := &pb.Alarm{}
+	situation.SetId(100)
+	situation.SetUei("uei.opennms.org/alarms/trigger")
+	situation.SetSeverity(uint32(pb.Severity_CRITICAL))
+	situation.SetReductionKey("situation::100")
+	situation.SetLastEventTime(uint64(time.Now().UnixMilli()))
+	situation.SetRelatedAlarm(members)
+
+	return situation
+}
+
+func TestSituations(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+
+		// alerts re-sent for the active member when the situation is received
+		wantResent int
+		wantLabel  string
+		wantEnded  bool
+
+		// alerts sent for a later update of the member
+		wantMember int
+
+		// alerts sent for the active member when the situation is cleared
+		wantReleased int
+	}{
+		{"unchanged", "", 0, "", false, 1, 0},
+		{"tag", "tag", 2, "100", false, 1, 2},
+		{"suppress", "suppress", 1, "", true, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(
+				WithConfig(&Config{Situations: SituationConfig{Enabled: true, Members: tt.mode}}),
+				WithSink(&testSink{name: "test"}),
+			)
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+			q := srv.sinks[0]
+
+			member := testAlarm()
+			member.SetLastEventTime(uint64(time.Now().UnixMilli()))
+			srv.handleAlarms([]instanceAlarm{{alarm: member, instanceID: "instance1", now: time.Now()}}, nil)
+			receive(t, q)
+
+			srv.handleAlarms([]instanceAlarm{{alarm: testSituation(member), instanceID: "instance1", now: time.Now()}}, nil)
+			alerts := receive(t, q)
+			if len(alerts) != tt.wantResent+1 {
+				t.Fatalf("handleAlarms() sent %d alerts for situation, want %d", len(alerts), tt.wantResent+1)
+			}
+
+			// the situation is sent last
+			situation := alerts[len(alerts)-1]
+			if situation.Labels[situationLabel] != "100" {
+				t.Errorf("situation label %s = %q, want %q", situationLabel, situation.Labels[situationLabel], "100")
+			}
+			if got := situation.Annotations["related_alarm_ids"]; got != "42" {
+				t.Errorf("related_alarm_ids = %q, want %q", got, "42")
+			}
+			if got := situation.Annotations["related_alarms"]; got != "42: uei.opennms.org/nodes/nodeDown (node1)" {
+				t.Errorf("related_alarms = %q, want member alarm", got)
+			}
+
+			// the untagged alert is resolved when tagging
+			if tt.wantResent > 1 {
+				if previous := alerts[0]; previous.Labels[situationLabel] != "" || previous.EndsAt.After(time.Now()) {
+					t.Errorf("previous member alert = %v until %v, want untagged and resolved", previous.Labels, previous.EndsAt)
+				}
+			}
+
+			if tt.wantResent > 0 {
+				resent := alerts[tt.wantResent-1]
+				if resent.Labels[situationLabel] != tt.wantLabel {
+					t.Errorf("member label %s = %q, want %q", situationLabel, resent.Labels[situationLabel], tt.wantLabel)
+				}
+				if ended := !resent.EndsAt.After(time.Now()); ended != tt.wantEnded {
+					t.Errorf("member ended = %v, want %v", ended, tt.wantEnded)
+				}
+			}
+
+			srv.handleAlarms([]instanceAlarm{{alarm: member, instanceID: "instance1", now: time.Now()}}, nil)
+			alerts = receive(t, q)
+			if len(alerts) != tt.wantMember {
+				t.Fatalf("handleAlarms() sent %d alerts for member, want %d", len(alerts), tt.wantMember)
+			}
+			if tt.wantMember > 0 && alerts[0].Labels[situationLabel] != tt.wantLabel {
+				t.Errorf("member label %s = %q, want %q", situationLabel, alerts[0].Labels[situationLabel], tt.wantLabel)
+			}
+
+			// members are sent as normal once the situation is cleared, which
+			// usually has no members
+			cleared := testSituation()
+			cleared.SetSeverity(uint32(pb.Severity_CLEARED))
+			srv.handleAlarms([]instanceAlarm{{alarm: cleared, instanceID: "instance1", now: time.Now()}}, nil)
+			alerts = receive(t, q)
+			if len(alerts) != tt.wantReleased+1 {
+				t.Fatalf("handleAlarms() sent %d alerts for cleared situation, want %d", len(alerts), tt.wantReleased+1)
+			}
+			if got := alerts[len(alerts)-1]; got.Labels[situationLabel] != "100" || got.EndsAt.After(time.Now()) {
+				t.Errorf("cleared situation = %v until %v, want resolved situation", got.Labels, got.EndsAt)
+			}
+
+			// the member is sent again untagged and firing
+			if tt.wantReleased > 0 {
+				released := alerts[tt.wantReleased-1]
+				if released.Labels[situationLabel] != "" || !released.EndsAt.After(time.Now()) {
+					t.Errorf("released member = %v until %v, want untagged and firing", released.Labels, released.EndsAt)
+				}
+			}
+			if _, ok := srv.situations.situation("instance1", member.GetId()); ok {
+				t.Error("member still in situation after clear")
+			}
+
+			srv.handleAlarms([]instanceAlarm{{alarm: member, instanceID: "instance1", now: time.Now()}}, nil)
+			alerts = receive(t, q)
+			if len(alerts) != 1 || alerts[0].Labels[situationLabel] != "" {
+				t.Errorf("handleAlarms() sent %v for member after clear, want one untagged alert", alerts)
+			}
+		})
+	}
+}
+
+func TestNewSituationsInvalid(t *testing.T) {
+	if _, err := newSituations(SituationConfig{Enabled: true, Members: "drop"}); err == nil {
+		t.Error("newSituations() error = nil, want error")
+	}
+}
+
+func TestSituationsTagRelabel(t *testing.T) {
+	srv, err := NewServiceSyncServer(
+		WithConfig(&Config{
+			Situations: SituationConfig{Enabled: true, Members: "tag"},
+			RelabelConfigs: []RelabelConfig{{
+				SourceLabels: []string{situationLabel},
+				Regex:        ptr("(.+)"),
+				TargetLabel:  "correlated",
+				Replacement:  ptr("yes"),
+			}},
+		}),
+		WithSink(&testSink{name: "test"}),
+	)
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	q := srv.sinks[0]
+
+	member := testAlarm()
+	member.SetLastEventTime(uint64(time.Now().UnixMilli()))
+	srv.handleAlarms([]instanceAlarm{{alarm: member, instanceID: "instance1", now: time.Now()}}, nil)
+	receive(t, q)
+
+	srv.handleAlarms([]instanceAlarm{{alarm: testSituation(member), instanceID: "instance1", now: time.Now()}}, nil)
+	receive(t, q)
+
+	// the tagged alert matches the alert sent for a later update of the member
+	srv.handleAlarms([]instanceAlarm{{alarm: member, instanceID: "instance1", now: time.Now()}}, nil)
+	alerts := receive(t, q)
+	if len(alerts) != 1 {
+		t.Fatalf("handleAlarms() sent %d alerts for member, want 1", len(alerts))
+	}
+
+	v, ok := srv.active.get("instance1", member.GetId())
+	if !ok {
+		t.Fatal("member not active")
+	}
+	if v.alert.Labels["correlated"] != "yes" || !maps.Equal(v.alert.Labels, alerts[0].Labels) {
+		t.Errorf("active member labels = %v, want %v", v.alert.Labels, alerts[0].Labels)
+	}
+
+	// untagging relabels the original labels so nothing added for the
+	// situation is left behind
+	cleared := testSituation()
+	cleared.SetSeverity(uint32(pb.Severity_CLEARED))
+	srv.handleAlarms([]instanceAlarm{{alarm: cleared, instanceID: "instance1", now: time.Now()}}, nil)
+	alerts = receive(t, q)
+	if len(alerts) != 3 {
+		t.Fatalf("handleAlarms() sent %d alerts for cleared situation, want 3", len(alerts))
+	}
+	if got := alerts[1].Labels; got[situationLabel] != "" || got["correlated"] != "" {
+		t.Errorf("untagged member labels = %v, want no situation labels", got)
+	}
+}
+
+func TestSituationsSnapshotReleasesMembers(t *testing.T) {
+	srv, err := NewServiceSyncServer(
+		WithConfig(&Config{Situations: SituationConfig{Enabled: true, Members: "suppress"}}),
+		WithSink(&testSink{name: "test"}),
+	)
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+	q := srv.sinks[0]
+
+	member := testAlarm()
+	member.SetLastEventTime(uint64(time.Now().UnixMilli()))
+	srv.handleAlarms([]instanceAlarm{{alarm: member, instanceID: "instance1", now: time.Now()}}, nil)
+	receive(t, q)
+
+	srv.handleAlarms([]instanceAlarm{{alarm: testSituation(member), instanceID: "instance1", now: time.Now()}}, nil)
+	receive(t, q)
+
+	// suppressed members are not refreshed
+	if list := srv.active.refresh(time.Now(), srv.resolveTimeout); len(list) != 1 || list[0].Labels[situationLabel] != "100" {
+		t.Errorf("refresh() = %v, want only the situation", list)
+	}
+
+	// a snapshot without the situation sends the member again
+	srv.reconcile(alarmBatch{instanceID: "instance1", alarms: []instanceAlarm{{alarm: member, instanceID: "instance1"}}})
+	alerts := receive(t, q)
+	if len(alerts) != 2 {
+		t.Fatalf("reconcile() sent %d alerts, want 2", len(alerts))
+	}
+	if got := alerts[1]; got.Labels[situationLabel] != "" || !got.EndsAt.After(time.Now()) {
+		t.Errorf("released member = %v until %v, want firing member", got.Labels, got.EndsAt)
+	}
+}
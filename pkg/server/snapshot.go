@@ -0,0 +1,82 @@
+package server
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// seenAlarms tracks the alarm IDs seen for each instance, either from the
+// last snapshot or from updates received since then
+type seenAlarms struct {
+	mu        sync.RWMutex
+	alarms    map[string]map[uint64]bool
+	snapshots map[string]time.Time
+}
+
+func newSeenAlarms() *seenAlarms {
+	return &seenAlarms{
+		alarms:    make(map[string]map[uint64]bool),
+		snapshots: make(map[string]time.Time),
+	}
+}
+
+func (s *seenAlarms) add(instanceID string, alarmID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.alarms[instanceID]; !ok {
+		s.alarms[instanceID] = make(map[uint64]bool)
+	}
+	s.alarms[instanceID][alarmID] = true
+}
+
+func (s *seenAlarms) remove(instanceID string, alarmID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.alarms[instanceID], alarmID)
+}
+
+// replace sets the alarms seen for the instance to those in the snapshot and
+// returns the alarm IDs that were added and removed compared to what was
+// previously seen
+func (s *seenAlarms) replace(instanceID string, snapshot map[uint64]bool) (added, removed []uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.alarms[instanceID]
+	for id := range snapshot {
+		if !previous[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range previous {
+		if !snapshot[id] {
+			removed = append(removed, id)
+		}
+	}
+
+	s.alarms[instanceID] = snapshot
+	s.snapshots[instanceID] = time.Now()
+
+	return added, removed
+}
+
+// status returns the number of alarms seen and the time of the last snapshot
+// for the instance
+func (s *seenAlarms) status(instanceID string) (int, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.alarms[instanceID]), s.snapshots[instanceID]
+}
+
+// instances returns the IDs of all instances with seen alarms
+func (s *seenAlarms) instances() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return slices.Collect(maps.Keys(s.alarms))
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSeenAlarmsReplace(t *testing.T) {
+	tests := []struct {
+		name        string
+		seen        []uint64
+		snapshot    []uint64
+		wantAdded   []uint64
+		wantRemoved []uint64
+	}{
+		{"empty", nil, nil, nil, nil},
+		{"first snapshot", nil, []uint64{1, 2}, []uint64{1, 2}, nil},
+		{"unchanged", []uint64{1, 2}, []uint64{1, 2}, nil, nil},
+		{"added", []uint64{1}, []uint64{1, 2}, []uint64{2}, nil},
+		{"removed", []uint64{1, 2}, []uint64{1}, nil, []uint64{2}},
+		{"replaced", []uint64{1, 2}, []uint64{3}, []uint64{3}, []uint64{1, 2}},
+		{"all removed", []uint64{1, 2}, nil, nil, []uint64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSeenAlarms()
+			for _, id := range tt.seen {
+				s.add("instance", id)
+			}
+
+			// alarms for other instances are never affected
+			s.add("other", 1)
+
+			snapshot := make(map[uint64]bool)
+			for _, id := range tt.snapshot {
+				snapshot[id] = true
+			}
+
+			added, removed := s.replace("instance", snapshot)
+			slices.Sort(added)
+			slices.Sort(removed)
+
+			if !reflect.DeepEqual(added, tt.wantAdded) {
+				t.Errorf("replace() added = %v, want %v", added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("replace() removed = %v, want %v", removed, tt.wantRemoved)
+			}
+
+			// a second identical snapshot has no differences
+			added, removed = s.replace("instance", snapshot)
+			if len(added) != 0 || len(removed) != 0 {
+				t.Errorf("replace() second snapshot added = %v, removed = %v, want none", added, removed)
+			}
+
+			if !s.alarms["other"][1] {
+				t.Error("replace() modified alarms for another instance")
+			}
+		})
+	}
+}
+
+func TestSeenAlarmsRemove(t *testing.T) {
+	s := newSeenAlarms()
+	s.add("instance", 1)
+	s.remove("instance", 1)
+
+	// removing from an unknown instance is a no-op
+	s.remove("unknown", 1)
+
+	_, removed := s.replace("instance", map[uint64]bool{})
+	if len(removed) != 0 {
+		t.Errorf("replace() removed = %v, want none after remove", removed)
+	}
+}
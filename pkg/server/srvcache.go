@@ -55,3 +55,11 @@ func (c *srvCache) clearResolving() {
 
 	c.resolving = false
 }
+
+// status returns the cached URLs and when they expire and become stale
+func (c *srvCache) status() (urls []string, expiresAt time.Time, staleUntil time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.urls, c.expiresAt, c.staleUntil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// templateFuncs are the helper functions available to annotation templates
+var templateFuncs = template.FuncMap{
+	"stripHTML":  stripHTML,
+	"truncate":   truncate,
+	"formatTime": formatTime,
+	"toLower":    strings.ToLower,
+	"toUpper":    strings.ToUpper,
+	"trimSpace":  strings.TrimSpace,
+}
+
+// templateInstance is the Horizon instance an alarm was received from
+type templateInstance struct {
+	ID   string
+	Name string
+}
+
+// templateData is passed to annotation templates when they are rendered
+type templateData struct {
+	Alarm     *pb.Alarm
+	Node      *pb.NodeCriteria
+	Inventory *pb.Node
+	Interface *pb.SnmpInterface
+	Instance  templateInstance
+	Labels    map[string]string
+}
+
+type annotationTemplate struct {
+	name string
+	tmpl *template.Template
+}
+
+// annotationTemplates render annotations using text/template
+type annotationTemplates []annotationTemplate
+
+func newAnnotationTemplates(defs map[string]string) (annotationTemplates, error) {
+	t := make(annotationTemplates, 0, len(defs))
+	for name, def := range defs {
+		if !labelNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid name %q", name)
+		}
+
+		tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(def)
+		if err != nil {
+			return nil, err
+		}
+
+		t = append(t, annotationTemplate{name: name, tmpl: tmpl})
+	}
+
+	return t, nil
+}
+
+// render executes each template against data and adds the non-empty results
+// to dst. Templates that fail to render are skipped and the errors returned.
+func (t annotationTemplates) render(data templateData, dst map[string]string) error {
+	var errs []error
+	for _, at := range t {
+		var b strings.Builder
+		if err := at.tmpl.Execute(&b, data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if v := strings.TrimSpace(b.String()); v != "" {
+			dst[at.name] = v
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// stripHTML removes any HTML tags and unescapes entities, which is useful
+// as OpenNMS log messages and descriptions often contain HTML
+func stripHTML(s string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRegexp.ReplaceAllString(s, "")))
+}
+
+// truncate shortens s to at most n characters, replacing the last character
+// with an ellipsis if anything was removed
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if n < 1 || len(r) <= n {
+		return s
+	}
+
+	return string(r[:n-1]) + "…"
+}
+
+// formatTime formats a time using the provided layout. OpenNMS times are
+// provided as milliseconds since the epoch.
+func formatTime(layout string, v any) (string, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(layout), nil
+	case uint64:
+		return time.UnixMilli(int64(t)).Format(layout), nil
+	case int64:
+		return time.UnixMilli(t).Format(layout), nil
+	default:
+		return "", fmt.Errorf("cannot format %T as a time", v)
+	}
+}
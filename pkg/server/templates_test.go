@@ -0,0 +1,77 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnnotationTemplates(t *testing.T) {
+	alarm := testAlarm()
+	alarm.SetLogMessage("<p>Node <b>node1</b> is down &amp; unreachable</p>")
+	alarm.SetFirstEventTime(uint64(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixMilli()))
+
+	data := templateData{
+		Alarm:    alarm,
+		Node:     alarm.GetNodeCriteria(),
+		Instance: templateInstance{ID: "uuid", Name: "horizon"},
+		Labels:   map[string]string{"severity": "major"},
+	}
+
+	tests := []struct {
+		name    string
+		defs    map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"strip html", map[string]string{"summary": "{{ .Alarm.GetLogMessage | stripHTML }}"}, map[string]string{"summary": "Node node1 is down & unreachable"}, false},
+		{"truncate", map[string]string{"summary": "{{ .Alarm.GetLogMessage | stripHTML | truncate 10 }}"}, map[string]string{"summary": "Node node…"}, false},
+		{"format time", map[string]string{"since": `{{ formatTime "2006-01-02" .Alarm.GetFirstEventTime }}`}, map[string]string{"since": time.UnixMilli(int64(alarm.GetFirstEventTime())).Format("2006-01-02")}, false},
+		{"instance and node", map[string]string{"description": "{{ .Node.GetNodeLabel }} on {{ .Instance.Name }} is {{ .Labels.severity | toUpper }}"}, map[string]string{"description": "node1 on horizon is MAJOR"}, false},
+		{"empty skipped", map[string]string{"empty": "{{ .Alarm.GetAckUser }}"}, map[string]string{}, false},
+		{"render error", map[string]string{"bad": `{{ formatTime "2006" .Instance.Name }}`}, map[string]string{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := newAnnotationTemplates(tt.defs)
+			if err != nil {
+				t.Fatalf("newAnnotationTemplates() error = %v", err)
+			}
+
+			got := make(map[string]string)
+			if err := at.render(data, got); (err != nil) != tt.wantErr {
+				t.Errorf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("render() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAnnotationTemplatesInvalid(t *testing.T) {
+	if _, err := newAnnotationTemplates(map[string]string{"summary": "{{ .Alarm"}); err == nil {
+		t.Error("newAnnotationTemplates() error = nil for invalid template, want error")
+	}
+	if _, err := newAnnotationTemplates(map[string]string{"bad name": "x"}); err == nil {
+		t.Error("newAnnotationTemplates() error = nil for invalid name, want error")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n    int
+		s    string
+		want string
+	}{
+		{5, "short", "short"},
+		{4, "longer", "lon…"},
+		{0, "unchanged", "unchanged"},
+		{2, "héllo", "h…"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.n, tt.s); got != tt.want {
+			t.Errorf("truncate(%d, %q) = %q, want %q", tt.n, tt.s, got, tt.want)
+		}
+	}
+}
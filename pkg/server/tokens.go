@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"go.yaml.in/yaml/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenFileConfig is the format of the token file
+type tokenFileConfig struct {
+	Tokens []struct {
+		// Token is the bearer token clients must send
+		Token string `yaml:"token"`
+
+		// InstanceIDs limits the instances the token may send messages for,
+		// which is any instance when empty
+		InstanceIDs []string `yaml:"instance_ids"`
+	} `yaml:"tokens"`
+}
+
+// token is a loaded token, which is compared via its hash
+type token struct {
+	hash      [sha256.Size]byte
+	instances map[string]bool
+}
+
+// TokenFile holds the bearer tokens loaded from a file, which is reloaded
+// when the file changes
+type TokenFile struct {
+	path   string
+	logger *slog.Logger
+	tokens atomic.Pointer[[]token]
+}
+
+// NewTokenFile loads the tokens from the YAML file at path
+func NewTokenFile(path string, logger *slog.Logger) (*TokenFile, error) {
+	t := &TokenFile{
+		path:   path,
+		logger: logger,
+	}
+
+	if err := t.load(); err != nil {
+		return nil, fmt.Errorf("unable to load tokens: %w", err)
+	}
+
+	return t, nil
+}
+
+func (t *TokenFile) load() error {
+	b, err := os.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := parseTokens(b)
+	if err != nil {
+		return err
+	}
+	t.tokens.Store(&tokens)
+
+	return nil
+}
+
+func parseTokens(b []byte) ([]token, error) {
+	var cfg tokenFileConfig
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid token file: %w", err)
+	}
+
+	// an empty file is most likely partially written so is not loaded
+	if len(cfg.Tokens) == 0 {
+		return nil, fmt.Errorf("no tokens in token file")
+	}
+
+	tokens := make([]token, 0, len(cfg.Tokens))
+	for n, v := range cfg.Tokens {
+		if v.Token == "" {
+			return nil, fmt.Errorf("token %d: token is required", n)
+		}
+
+		tk := token{hash: sha256.Sum256([]byte(v.Token))}
+		if len(v.InstanceIDs) > 0 {
+			tk.instances = make(map[string]bool, len(v.InstanceIDs))
+			for _, id := range v.InstanceIDs {
+				tk.instances[id] = true
+			}
+		}
+		tokens = append(tokens, tk)
+	}
+
+	return tokens, nil
+}
+
+// Start watches the token file for changes until the context is cancelled
+func (t *TokenFile) Start(ctx context.Context) error {
+	return watchFile(ctx, t.path, t.load, t.logger)
+}
+
+// lookup returns the token matching value, comparing hashes in constant time
+func (t *TokenFile) lookup(value string) (token, bool) {
+	hash := sha256.Sum256([]byte(value))
+
+	var found token
+	ok := false
+	for _, tk := range *t.tokens.Load() {
+		if subtle.ConstantTimeCompare(hash[:], tk.hash[:]) == 1 {
+			found = tk
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+// bearerToken returns the bearer token from the authorization metadata
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	for _, v := range md.Get("authorization") {
+		scheme, value, found := strings.Cut(v, " ")
+		if found && strings.EqualFold(scheme, "bearer") && value != "" {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// TokenStreamInterceptor returns a stream interceptor that requires a valid
+// bearer token in the "authorization" metadata of each stream. Messages for
+// instances outside of the scope of the token are rejected. Rejected streams
+// are counted by reason. The health and reflection services are exempt.
+func (s *ServiceSyncServer) TokenStreamInterceptor(tokens *TokenFile) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if exemptMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		logger := s.logger.With("method", info.FullMethod)
+
+		value, ok := bearerToken(ss.Context())
+		if !ok {
+			s.streamsRejected.WithLabelValues(rejectedMissingToken).Inc()
+			logger.Warn("rejected stream without a bearer token")
+			return status.Error(codes.Unauthenticated, "missing bearer token")
+		}
+
+		tk, ok := tokens.lookup(value)
+		if !ok {
+			s.streamsRejected.WithLabelValues(rejectedInvalidToken).Inc()
+			logger.Warn("rejected stream with an invalid bearer token")
+			return status.Error(codes.Unauthenticated, "invalid bearer token")
+		}
+
+		// tokens without a scope may be used for any instance
+		if tk.instances == nil {
+			return handler(srv, ss)
+		}
+
+		return handler(srv, &identityStream{
+			ServerStream: ss,
+			allowed:      tk.instances,
+			logger:       logger,
+			rejected:     s.streamsRejected,
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const testTokens = `tokens:
+  - token: any-instance
+  - token: scoped
+    instance_ids: [uuid-1]
+`
+
+func testTokenFile(t *testing.T, contents string) (*TokenFile, string) {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "tokens.yml")
+	if err := os.WriteFile(file, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	tokens, err := NewTokenFile(file, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewTokenFile() error = %v", err)
+	}
+
+	return tokens, file
+}
+
+func TestTokenStreamInterceptor(t *testing.T) {
+	tokens, _ := testTokenFile(t, testTokens)
+
+	alarms := func(id string) proto.Message {
+		m := &pb.AlarmUpdateList{}
+		m.SetInstanceId(id)
+		return m
+	}
+
+	tests := []struct {
+		name          string
+		authorization []string
+		message       proto.Message
+		wantCode      codes.Code
+		wantReason    string
+	}{
+		{"unscoped token", []string{"Bearer any-instance"}, alarms("uuid-2"), codes.OK, ""},
+		{"scoped token", []string{"bearer scoped"}, alarms("uuid-1"), codes.OK, ""},
+		{"scoped token wrong instance", []string{"Bearer scoped"}, alarms("uuid-2"), codes.PermissionDenied, "instance_not_allowed"},
+		{"invalid token", []string{"Bearer wrong"}, alarms("uuid-1"), codes.Unauthenticated, "invalid_token"},
+		{"missing token", nil, alarms("uuid-1"), codes.Unauthenticated, "missing_token"},
+		{"wrong scheme", []string{"Basic any-instance"}, alarms("uuid-1"), codes.Unauthenticated, "missing_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithLogger(slog.New(slog.DiscardHandler)))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			ctx := context.Background()
+			for _, v := range tt.authorization {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", v)
+			}
+			md, _ := metadata.FromOutgoingContext(ctx)
+			stream := &testServerStream{ctx: metadata.NewIncomingContext(context.Background(), md), messages: []proto.Message{tt.message}}
+
+			interceptor := srv.TokenStreamInterceptor(tokens)
+			err = interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test"}, func(srv any, ss grpc.ServerStream) error {
+				return ss.RecvMsg(tt.message.ProtoReflect().New().Interface())
+			})
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("interceptor() code = %v, want %v (%v)", got, tt.wantCode, err)
+			}
+
+			wantRejected := 0
+			if tt.wantReason != "" {
+				wantRejected = 1
+				if got := testutil.ToFloat64(srv.streamsRejected.WithLabelValues(tt.wantReason)); got != 1 {
+					t.Errorf("rejected %s = %v, want 1", tt.wantReason, got)
+				}
+			}
+			if got := testutil.CollectAndCount(srv.streamsRejected); got != wantRejected {
+				t.Errorf("rejected series = %d, want %d", got, wantRejected)
+			}
+		})
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     int
+		wantErr  bool
+	}{
+		{"tokens", testTokens, 2, false},
+		{"empty", "", 0, true},
+		{"missing token", "tokens:\n  - instance_ids: [uuid-1]\n", 0, true},
+		{"unknown field", "tokens:\n  - token: a\n    instances: [uuid-1]\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTokens([]byte(tt.contents))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTokens() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("parseTokens() = %d tokens, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenFileReload(t *testing.T) {
+	tokens, file := testTokenFile(t, testTokens)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- tokens.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	}()
+
+	// give the watcher time to start
+	time.Sleep(100 * time.Millisecond)
+
+	// an invalid file keeps the previous tokens
+	if err := os.WriteFile(file, []byte("tokens: [{}]\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := tokens.lookup("any-instance"); !ok {
+		t.Fatal("lookup() after invalid file = false, want true")
+	}
+
+	if err := os.WriteFile(file, []byte("tokens:\n  - token: rotated\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, rotated := tokens.lookup("rotated")
+		_, old := tokens.lookup("any-instance")
+		if rotated && !old {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lookup() rotated = %v, old = %v, want reloaded tokens", rotated, old)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTokenStreamInterceptorExempt(t *testing.T) {
+	tokens, _ := testTokenFile(t, testTokens)
+
+	tests := []struct {
+		name     string
+		method   string
+		wantCode codes.Code
+	}{
+		{"health check", "/grpc.health.v1.Health/Check", codes.OK},
+		{"reflection", "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", codes.OK},
+		{"receiver", pb.NmsInventoryServiceSync_AlarmUpdate_FullMethodName, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, err := NewServiceSyncServer(WithLogger(slog.New(slog.DiscardHandler)))
+			if err != nil {
+				t.Fatalf("NewServiceSyncServer() error = %v", err)
+			}
+
+			interceptor := srv.TokenStreamInterceptor(tokens)
+			err = interceptor(nil, &testServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: tt.method}, func(srv any, ss grpc.ServerStream) error {
+				return nil
+			})
+
+			if got := status.Code(err); got != tt.wantCode {
+				t.Errorf("interceptor() code = %v, want %v (%v)", got, tt.wantCode, err)
+			}
+
+			wantRejected := 0
+			if tt.wantCode != codes.OK {
+				wantRejected = 1
+			}
+			if got := testutil.CollectAndCount(srv.streamsRejected); got != wantRejected {
+				t.Errorf("rejected series = %d, want %d", got, wantRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	walFile = "queue.wal"
+	ackFile = "queue.ack"
+
+	// the log is rewritten without acknowledged records once it reaches this size
+	walCompactSize = 1 << 20
+)
+
+// wal is a write-ahead log of alarm batches on local disk. Batches are
+// appended before they are queued and acknowledged once they have been
+// accepted by Alertmanager, so any batches that were not delivered are
+// replayed on startup.
+//
+// The acknowledged state is kept as the last sequence number that was
+// acknowledged along with the set of earlier batches that were not, so a
+// batch that fails delivery does not hold back the batches after it. Batches
+// that fail delivery are also retried while running.
+type wal struct {
+	mu      sync.Mutex
+	dir     string
+	f       *os.File
+	size    int64
+	seq     uint64
+	acked   uint64
+	unacked map[uint64]bool
+	failed  map[uint64]bool
+}
+
+// walRecord is the on-disk representation of an alarmBatch
+type walRecord struct {
+	Seq           uint64       `json:"seq"`
+	InstanceID    string       `json:"instance_id,omitempty"`
+	InstanceName  string       `json:"instance_name,omitempty"`
+	ForeignSource string       `json:"foreign_source,omitempty"`
+	Snapshot      bool         `json:"snapshot,omitempty"`
+	Received      time.Time    `json:"received"`
+	Alarms        [][]byte     `json:"alarms,omitempty"`
+	Services      []walService `json:"services,omitempty"`
+}
+
+type walService struct {
+	ForeignType    string `json:"foreign_type"`
+	ForeignSource  string `json:"foreign_source"`
+	ForeignService string `json:"foreign_service"`
+	Healthy        bool   `json:"healthy"`
+}
+
+// openWAL opens or creates the write-ahead log in dir and returns any batches
+// that had not been acknowledged
+func openWAL(dir string) (*wal, []alarmBatch, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, nil, err
+	}
+
+	w := &wal{
+		dir:     dir,
+		unacked: make(map[uint64]bool),
+		failed:  make(map[uint64]bool),
+	}
+
+	// load acknowledged position and any earlier unacknowledged batches
+	if b, err := os.ReadFile(filepath.Join(dir, ackFile)); err == nil {
+		for n, field := range strings.Fields(string(b)) {
+			seq, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid %s: %w", ackFile, err)
+			}
+			if n == 0 {
+				w.acked = seq
+			} else {
+				w.unacked[seq] = true
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	w.seq = w.acked
+
+	records, valid, err := w.read()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// discard any partial write so new records start on a new line
+	if err := os.Truncate(filepath.Join(dir, walFile), valid); err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	// only batches still in the log are unacknowledged
+	w.unacked = make(map[uint64]bool, len(records))
+	pending := make([]alarmBatch, 0, len(records))
+	for _, r := range records {
+		w.seq = max(w.seq, r.Seq)
+
+		b, err := r.batch()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid record %d: %w", r.Seq, err)
+		}
+		w.unacked[r.Seq] = true
+		pending = append(pending, b)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	w.f = f
+	w.size = info.Size()
+
+	return w, pending, nil
+}
+
+// read returns all records in the log that have not been acknowledged along
+// with the length of the log up to the last complete record
+func (w *wal) read() ([]walRecord, int64, error) {
+	f, err := os.Open(filepath.Join(w.dir, walFile))
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var valid int64
+	records := make([]walRecord, 0)
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// a partial write from a crash can only be the last line
+			break
+		}
+
+		var r walRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			break
+		}
+		valid += int64(len(line))
+
+		if w.pending(r.Seq) {
+			records = append(records, r)
+		}
+	}
+
+	return records, valid, nil
+}
+
+// pending returns true if the record has not been acknowledged
+func (w *wal) pending(seq uint64) bool {
+	return seq > w.acked || w.unacked[seq]
+}
+
+// append writes the batch to the log and sets the sequence number of the batch
+func (w *wal) append(b *alarmBatch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	r, err := newWALRecord(w.seq+1, *b)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n, err := w.f.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+
+	w.seq = r.Seq
+	w.unacked[r.Seq] = true
+	b.seq = r.Seq
+
+	return nil
+}
+
+// ack marks the provided sequence numbers as delivered and truncates the log
+// when possible
+func (w *wal) ack(seqs []uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.acknowledge(seqs)
+}
+
+// acknowledge marks the provided sequence numbers as delivered, the lock must
+// be held
+func (w *wal) acknowledge(seqs []uint64) error {
+	for _, seq := range seqs {
+		delete(w.unacked, seq)
+	}
+
+	// everything appended has been delivered apart from the unacknowledged
+	// records, which are saved after the position
+	w.acked = w.seq
+	fields := []string{strconv.FormatUint(w.acked, 10)}
+	for _, seq := range slices.Sorted(maps.Keys(w.unacked)) {
+		fields = append(fields, strconv.FormatUint(seq, 10))
+	}
+
+	if err := writeFileAtomic(filepath.Join(w.dir, ackFile), []byte(strings.Join(fields, " "))); err != nil {
+		return err
+	}
+
+	// truncate once everything is delivered
+	if len(w.unacked) == 0 {
+		if err := w.f.Truncate(0); err != nil {
+			return err
+		}
+		w.size = 0
+
+		return nil
+	}
+
+	if w.size >= walCompactSize {
+		return w.compact()
+	}
+
+	return nil
+}
+
+// fail marks the provided sequence numbers as not delivered so they are
+// returned by retry
+func (w *wal) fail(seqs []uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, seq := range seqs {
+		w.failed[seq] = true
+	}
+}
+
+// retry returns the batches that failed delivery so they can be sent again.
+// Failed batches received before the cutoff are acknowledged instead of being
+// returned, so the log does not grow without bound while Alertmanager is
+// unavailable, and the number of alarms in those batches is returned. A zero
+// cutoff keeps all failed batches.
+func (w *wal) retry(cutoff time.Time) ([]alarmBatch, int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.failed) == 0 {
+		return nil, 0, nil
+	}
+
+	records, _, err := w.read()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var dropped int
+	expired := make([]uint64, 0)
+	batches := make([]alarmBatch, 0, len(w.failed))
+	for _, r := range records {
+		if !w.failed[r.Seq] {
+			continue
+		}
+
+		if !cutoff.IsZero() && r.Received.Before(cutoff) {
+			expired = append(expired, r.Seq)
+			dropped += len(r.Alarms) + len(r.Services)
+			delete(w.failed, r.Seq)
+			continue
+		}
+
+		b, err := r.batch()
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid record %d: %w", r.Seq, err)
+		}
+		batches = append(batches, b)
+		delete(w.failed, r.Seq)
+	}
+
+	if len(expired) > 0 {
+		if err := w.acknowledge(expired); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return batches, dropped, nil
+}
+
+// compact rewrites the log with only the unacknowledged records
+func (w *wal) compact() error {
+	records, _, err := w.read()
+	if err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	name := filepath.Join(w.dir, walFile)
+	if err := writeFileAtomic(name, []byte(buf.String())); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	w.f.Close()
+	w.f = f
+	w.size = int64(buf.Len())
+
+	return nil
+}
+
+func (w *wal) depth() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.unacked)
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.f.Close()
+}
+
+func newWALRecord(seq uint64, b alarmBatch) (walRecord, error) {
+	r := walRecord{
+		Seq:           seq,
+		InstanceID:    b.instanceID,
+		InstanceName:  b.instanceName,
+		ForeignSource: b.foreignSource,
+		Snapshot:      b.snapshot,
+		Received:      time.Now(),
+	}
+
+	for _, ia := range b.alarms {
+		if ia.service != nil {
+			r.Services = append(r.Services, walService{
+				ForeignType:    ia.service.foreignType,
+				ForeignSource:  ia.service.foreignSource,
+				ForeignService: ia.service.foreignService,
+				Healthy:        ia.service.healthy,
+			})
+			continue
+		}
+
+		data, err := proto.Marshal(ia.alarm)
+		if err != nil {
+			return walRecord{}, err
+		}
+		r.Alarms = append(r.Alarms, data)
+	}
+
+	if len(b.alarms) > 0 {
+		r.Received = b.alarms[0].now
+	}
+
+	return r, nil
+}
+
+func (r walRecord) batch() (alarmBatch, error) {
+	b := alarmBatch{
+		seq:           r.Seq,
+		instanceID:    r.InstanceID,
+		instanceName:  r.InstanceName,
+		foreignSource: r.ForeignSource,
+		snapshot:      r.Snapshot,
+		alarms:        make([]instanceAlarm, 0, len(r.Alarms)+len(r.Services)),
+	}
+
+	for _, data := range r.Alarms {
+		alarm := new(pb.Alarm)
+		if err := proto.Unmarshal(data, alarm); err != nil {
+			return alarmBatch{}, err
+		}
+
+		b.alarms = append(b.alarms, instanceAlarm{
+			alarm:        alarm,
+			instanceID:   r.InstanceID,
+			instanceName: r.InstanceName,
+			now:          r.Received,
+		})
+	}
+
+	for _, svc := range r.Services {
+		b.alarms = append(b.alarms, instanceAlarm{
+			service: &serviceState{
+				foreignType:    svc.ForeignType,
+				foreignSource:  svc.ForeignSource,
+				foreignService: svc.ForeignService,
+				healthy:        svc.Healthy,
+			},
+			now: r.Received,
+		})
+	}
+
+	return b, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/andrewheberle/onms-grpc-receiver/pkg/spog"
+)
+
+func testBatch(instanceID string, alarmIDs ...uint64) alarmBatch {
+	b := alarmBatch{
+		instanceID:   instanceID,
+		instanceName: "name",
+	}
+	for _, id := range alarmIDs {
+		alarm := new(pb.Alarm)
+		alarm.SetId(id)
+		alarm.SetUei("uei.opennms.org/test")
+
+		b.alarms = append(b.alarms, instanceAlarm{
+			alarm:        alarm,
+			instanceID:   instanceID,
+			instanceName: "name",
+			now:          time.Now(),
+		})
+	}
+
+	return b
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	w, pending, err := openWAL(dir)
+	if err != nil {
+		t.Fatalf("openWAL() error = %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("openWAL() pending = %d, want 0", len(pending))
+	}
+
+	b1, b2, b3 := testBatch("instance", 1), testBatch("instance", 2, 3), testBatch("instance", 4)
+	for _, b := range []*alarmBatch{&b1, &b2, &b3} {
+		if err := w.append(b); err != nil {
+			t.Fatalf("append() error = %v", err)
+		}
+	}
+	if b1.seq != 1 || b2.seq != 2 || b3.seq != 3 {
+		t.Errorf("append() seqs = %d, %d, %d, want 1, 2, 3", b1.seq, b2.seq, b3.seq)
+	}
+
+	// acknowledging out of order only leaves the unacknowledged batch
+	if err := w.ack([]uint64{b1.seq, b3.seq}); err != nil {
+		t.Fatalf("ack() error = %v", err)
+	}
+	if err := w.close(); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+
+	w, pending, err = openWAL(dir)
+	if err != nil {
+		t.Fatalf("openWAL() error = %v", err)
+	}
+	defer w.close()
+
+	if len(pending) != 1 {
+		t.Fatalf("openWAL() pending = %d, want 1", len(pending))
+	}
+	if got := pending[0]; got.seq != 2 || len(got.alarms) != 2 || got.alarms[1].alarm.GetId() != 3 {
+		t.Errorf("openWAL() pending[0] = %+v, want batch 2 with alarms 2 and 3", got)
+	}
+
+	// new batches continue the sequence
+	b4 := testBatch("instance", 5)
+	if err := w.append(&b4); err != nil {
+		t.Fatalf("append() error = %v", err)
+	}
+	if b4.seq != 4 {
+		t.Errorf("append() seq = %d, want 4", b4.seq)
+	}
+
+	// acknowledging everything truncates the log
+	if err := w.ack([]uint64{2, 3, 4}); err != nil {
+		t.Fatalf("ack() error = %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("log size = %d, want 0", info.Size())
+	}
+	if got := w.depth(); got != 0 {
+		t.Errorf("depth() = %d, want 0", got)
+	}
+}
+
+func TestWALPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	w, _, err := openWAL(dir)
+	if err != nil {
+		t.Fatalf("openWAL() error = %v", err)
+	}
+	b := testBatch("instance", 1)
+	if err := w.append(&b); err != nil {
+		t.Fatalf("append() error = %v", err)
+	}
+	w.close()
+
+	// simulate a crash part way through a write
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	f.WriteString(`{"seq":2,"instance_id":"ins`)
+	f.Close()
+
+	w, pending, err := openWAL(dir)
+	if err != nil {
+		t.Fatalf("openWAL() error = %v", err)
+	}
+	defer w.close()
+
+	if len(pending) != 1 || pending[0].seq != 1 {
+		t.Errorf("openWAL() pending = %+v, want batch 1 only", pending)
+	}
+
+	// records written after the partial write are still readable
+	b = testBatch("instance", 2)
+	if err := w.append(&b); err != nil {
+		t.Fatalf("append() error = %v", err)
+	}
+	records, _, err := w.read()
+	if err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if len(records) != 2 {
+		t.Errorf("read() records = %d, want 2", len(records))
+	}
+}
+
+func TestWALFailedBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	w, _, err := openWAL(dir)
+	if err != nil {
+		t.Fatalf("openWAL() error = %v", err)
+	}
+
+	// the first batch is never delivered
+	failed := testBatch("instance", 1)
+	if err := w.append(&failed); err != nil {
+		t.Fatalf("append() error = %v", err)
+	}
+
+	// later batches are large enough to compact the log once delivered
+	for id := uint64(2); id < 5; id++ {
+		b := testBatch("instance", id)
+		b.alarms[0].alarm.SetDescription(strings.Repeat("x", walCompactSize/2))
+		if err := w.append(&b); err != nil {
+			t.Fatalf("append() error = %v", err)
+		}
+		if err := w.ack([]uint64{b.seq}); err != nil {
+			t.Fatalf("ack() error = %v", err)
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size() >= walCompactSize {
+		t.Errorf("log size = %d, want less than %d", info.Size(), walCompactSize)
+	}
+	if got := w.depth(); got != 1 {
+		t.Errorf("depth() = %d, want 1", got)
+	}
+	w.close()
+
+	// only the failed batch is replayed
+	w, pending, err := openWAL(dir)
+	if err != nil {
+		t.Fatalf("openWAL() error = %v", err)
+	}
+	defer w.close()
+
+	if len(pending) != 1 || pending[0].seq != failed.seq {
+		t.Fatalf("openWAL() pending = %+v, want the failed batch", pending)
+	}
+
+	// new batches continue the sequence
+	b := testBatch("instance", 5)
+	if err := w.append(&b); err != nil {
+		t.Fatalf("append() error = %v", err)
+	}
+	if b.seq != 5 {
+		t.Errorf("append() seq = %d, want 5", b.seq)
+	}
+}
+
+func TestWALRetry(t *testing.T) {
+	w, _, err := openWAL(t.TempDir())
+	if err != nil {
+		t.Fatalf("openWAL() error = %v", err)
+	}
+	defer w.close()
+
+	b1 := testBatch("instance", 1)
+	b2 := testBatch("instance", 2)
+	for _, b := range []*alarmBatch{&b1, &b2} {
+		if err := w.append(b); err != nil {
+			t.Fatalf("append() error = %v", err)
+		}
+	}
+
+	// nothing is retried until delivery fails
+	if batches, _, err := w.retry(time.Time{}); err != nil || len(batches) != 0 {
+		t.Fatalf("retry() = %d batches, %v, want none", len(batches), err)
+	}
+
+	w.fail([]uint64{b1.seq})
+	if err := w.ack([]uint64{b2.seq}); err != nil {
+		t.Fatalf("ack() error = %v", err)
+	}
+
+	batches, dropped, err := w.retry(time.Time{})
+	if err != nil {
+		t.Fatalf("retry() error = %v", err)
+	}
+	if len(batches) != 1 || batches[0].seq != b1.seq || dropped != 0 {
+		t.Fatalf("retry() = %+v, %d dropped, want batch %d", batches, dropped, b1.seq)
+	}
+
+	// a batch is only returned once per failure
+	if batches, _, _ := w.retry(time.Time{}); len(batches) != 0 {
+		t.Errorf("retry() = %d batches, want none", len(batches))
+	}
+
+	// batches older than the cutoff are dropped
+	w.fail([]uint64{b1.seq})
+	batches, dropped, err = w.retry(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("retry() error = %v", err)
+	}
+	if len(batches) != 0 || dropped != 1 {
+		t.Errorf("retry() = %d batches, %d dropped, want none and 1 dropped", len(batches), dropped)
+	}
+	if got := w.depth(); got != 0 {
+		t.Errorf("depth() = %d, want 0", got)
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	var mu sync.Mutex
+	var requests, delivered int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// fail the first delivery
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		delivered++
+	}))
+	defer ts.Close()
+
+	srv, err := NewServiceSyncServer(
+		WithAlertmanagerUrl([]string{ts.URL}),
+		WithQueueDir(t.TempDir()),
+		WithQueueRetryInterval(time.Millisecond*10),
+		WithBatchMaxSize(1),
+	)
+	if err != nil {
+		t.Fatalf("NewServiceSyncServer() error = %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- srv.Start()
+	}()
+	defer func() {
+		srv.Shutdown()
+		<-done
+	}()
+
+	b := testBatch("instance", 1)
+	b.alarms[0].alarm.SetSeverity(uint32(pb.Severity_MAJOR))
+	if err := srv.enqueue(b); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+
+	// the failed batch is delivered without a restart
+	deadline := time.Now().Add(time.Second * 5)
+	for srv.wal.depth() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch was not delivered")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests < 2 || delivered != 1 {
+		t.Errorf("requests = %d, delivered = %d, want a failed request then 1 delivered", requests, delivered)
+	}
+}
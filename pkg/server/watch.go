@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile calls load whenever the file is written, replaced or the symlink
+// to it changes, in the same way that certinel watches certificates. The
+// directory of the file is watched so replacements of mounted Kubernetes
+// ConfigMaps and Secrets are seen. Errors from load are logged and the
+// previously loaded contents are kept.
+func watchFile(ctx context.Context, path string, load func() error, logger *slog.Logger) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	path = filepath.Clean(path)
+	dir, _ := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	realPath, _ := filepath.EvalSymlinks(path)
+
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("unable to create watcher: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-watcher.Events:
+			currentPath, _ := filepath.EvalSymlinks(path)
+
+			written := filepath.Clean(event.Name) == path && event.Op&(fsnotify.Create|fsnotify.Write) != 0
+			relinked := currentPath != "" && currentPath != realPath
+			if !written && !relinked {
+				continue
+			}
+			realPath = currentPath
+
+			if err := load(); err != nil {
+				logger.Error("problem reloading file, keeping previous contents", "file", path, "error", err)
+				continue
+			}
+			logger.Info("reloaded file", "file", path)
+		case err := <-watcher.Errors:
+			return err
+		}
+	}
+}